@@ -19,9 +19,11 @@ func archiveCmd(args []string) {
 	flagSet := flag.NewFlagSet(fmt.Sprintf("%s archive", os.Args[0]), flag.ExitOnError)
 	quiet := flagSet.Bool("quiet", false, "disable progress output")
 	token := flagSet.String("socrata-app-token", "", "Socrata App Token (also src SOCRATA_APP_TOKEN env)")
+	retries := flagSet.Int("socrata-retries", DefaultRetryPolicy.MaxRetries, "number of times to retry failed Socrata API requests")
 	if err := flagSet.Parse(args); err != nil {
 		log.Fatal(err)
 	}
+	socrataRetry.MaxRetries = *retries
 	if *token == "" {
 		*token = os.Getenv("SOCRATA_APP_TOKEN")
 	}
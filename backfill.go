@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+)
+
+// backfillRange selects the records to reload: those with a :created_at in [From, To)
+// and matching the SoQL Where. Zero or empty bounds are unset.
+type backfillRange struct {
+	From  time.Time
+	To    time.Time
+	Where string
+}
+
+// parseBackfillBound parses a -from or -to value as a date (YYYY-MM-DD) or an RFC3339
+// timestamp. A -to date includes the whole day.
+func parseBackfillBound(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q (expected YYYY-MM-DD or RFC3339)", s)
+	}
+	return t, nil
+}
+
+// SoQL returns the condition selecting the range in Socrata
+func (r backfillRange) SoQL() string {
+	var where []string
+	if !r.From.IsZero() {
+		where = append(where, fmt.Sprintf(":created_at >= '%s'", r.From.UTC().Format(time.RFC3339)))
+	}
+	if !r.To.IsZero() {
+		where = append(where, fmt.Sprintf(":created_at < '%s'", r.To.UTC().Format(time.RFC3339)))
+	}
+	if r.Where != "" {
+		where = append(where, "("+r.Where+")")
+	}
+	return andWhere(where...)
+}
+
+// DeleteSQL builds the DML which removes the range from target. The -from/-to bounds are
+// applied to the column loaded from :created_at so only the matching partitions are scanned
+// when it is the partitioning field. An arbitrary Where can't be translated to BigQuery so
+// rows are instead matched by _id against ids, a table of the Socrata :id values in the range.
+func (r backfillRange) DeleteSQL(target, ids string, s TableSchema) (string, error) {
+	bounded := !r.From.IsZero() || !r.To.IsZero()
+	if !bounded && r.Where == "" {
+		return "", fmt.Errorf("refusing to delete all rows; set -from, -to or -where")
+	}
+	var where []string
+	if bounded {
+		column := createdAtColumn(s)
+		if column == "" {
+			return "", fmt.Errorf("-from and -to require a schema field with source_field \":created_at\"")
+		}
+		var layout, cast string
+		switch s[column].Type {
+		case bigquery.TimestampFieldType:
+			layout, cast = "2006-01-02 15:04:05-07:00", "TIMESTAMP"
+		case bigquery.DateTimeFieldType:
+			layout, cast = "2006-01-02 15:04:05", "DATETIME"
+		default:
+			return "", fmt.Errorf("-from and -to require field %q to be TIMESTAMP or DATETIME (got %s)", column, s[column].Type)
+		}
+		if !r.From.IsZero() {
+			where = append(where, fmt.Sprintf("%s >= %s '%s'", bqIdentifier(column), cast, r.From.UTC().Format(layout)))
+		}
+		if !r.To.IsZero() {
+			where = append(where, fmt.Sprintf("%s < %s '%s'", bqIdentifier(column), cast, r.To.UTC().Format(layout)))
+		}
+		if tp, _ := s.TimePartitioning(); tp != nil && tp.Field != column {
+			where = append(where, s.PartitionFilter())
+		}
+	} else {
+		where = append(where, s.PartitionFilter())
+	}
+	if r.Where != "" {
+		if ids == "" {
+			return "", fmt.Errorf("-where requires a table of Socrata ids")
+		}
+		where = append(where, fmt.Sprintf("_id IN (SELECT _id FROM %s)", ids))
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", target, andWhere(where...)), nil
+}
+
+// backfillSQL builds a transaction which deletes the range from target with deleteSQL and
+// inserts the reloaded rows from staging, so the table never has the range missing or twice
+func backfillSQL(deleteSQL, target, staging string, s TableSchema) string {
+	var columns []string
+	for name := range s {
+		columns = append(columns, bqIdentifier(name))
+	}
+	sort.Strings(columns)
+	return fmt.Sprintf(`BEGIN TRANSACTION;
+%s;
+INSERT INTO %s (%s) SELECT %s FROM %s;
+COMMIT TRANSACTION;`, deleteSQL, target, strings.Join(columns, ", "), strings.Join(columns, ", "), staging)
+}
+
+func backfillCmd(args []string) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s backfill", os.Args[0]), flag.ExitOnError)
+	quiet := flagSet.Bool("quiet", false, "disable progress output")
+	token := flagSet.String("socrata-app-token", "", "Socrata App Token (also src SOCRATA_APP_TOKEN env)")
+	retries := flagSet.Int("socrata-retries", DefaultRetryPolicy.MaxRetries, "number of times to retry failed Socrata API requests")
+	from := flagSet.String("from", "", "reload records with a :created_at on or after this date (YYYY-MM-DD) or time (RFC3339)")
+	to := flagSet.String("to", "", "reload records with a :created_at before this time (RFC3339), or on or before this date (YYYY-MM-DD)")
+	where := flagSet.String("where", "", "reload records matching this SoQL WHERE clause")
+	if err := flagSet.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	socrataRetry.MaxRetries = *retries
+	if *token == "" {
+		*token = os.Getenv("SOCRATA_APP_TOKEN")
+	}
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "missing --socrata-app-token or environment variable SOCRATA_APP_TOKEN")
+		os.Exit(1)
+	}
+
+	if flagSet.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "missing filename")
+		os.Exit(1)
+	}
+	var r backfillRange
+	var err error
+	if r.From, err = parseBackfillBound(*from, false); err != nil {
+		log.Fatalf("-from %s", err)
+	}
+	if r.To, err = parseBackfillBound(*to, true); err != nil {
+		log.Fatalf("-to %s", err)
+	}
+	r.Where = *where
+	if r.From.IsZero() && r.To.IsZero() && r.Where == "" {
+		fmt.Fprintln(os.Stderr, "missing -from, -to or -where")
+		os.Exit(1)
+	}
+	for _, configFile := range flagSet.Args() {
+		backfillOne(configFile, *quiet, *token, r)
+	}
+}
+
+// backfillOne reloads the rows in r from Socrata into a staging table and then replaces
+// them in the BigQuery table in a single transaction
+func backfillOne(configFile string, quiet bool, token string, r backfillRange) {
+	cf, err := LoadConfigFile(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	datasetID := cf.DatasetID()
+	where := andWhere(cf.BigQuery.WhereFilter, r.SoQL())
+	fmt.Printf("Backfilling Socrata %s where %s\n", datasetID, where)
+
+	socrataCount, err := CountV3(ctx, cf.APIBase(), datasetID, where, token)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Socrata Records: %d\n", socrataCount)
+
+	bqclient, err := bigquery.NewClient(ctx, cf.BigQuery.ProjectID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dataset := bqclient.Dataset(cf.BigQuery.DatasetName)
+	bqTable := dataset.Table(cf.BigQuery.TableName)
+	tmd, err := bqTable.Metadata(ctx)
+	if err != nil {
+		log.Fatalf("Error fetching BigQuery Table %s.%s %s", cf.BigQuery.DatasetName, cf.BigQuery.TableName, err)
+	}
+	fmt.Printf("BQ Table %s OK (last modified %s)\n", tmd.FullID, tmd.LastModifiedTime)
+
+	var ids string
+	if r.Where != "" {
+		if _, ok := cf.Schema["_id"]; !ok {
+			log.Fatalf("-where requires schema field %q", "_id")
+		}
+		t, count, err := loadSocrataIDs(ctx, dataset, cf, datasetID, where, token)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if count == 0 {
+			fmt.Printf("0 records to backfill\n")
+			return
+		}
+		defer dropStagingTable(ctx, t)
+		ids = sqlTable(t)
+	}
+	deleteSQL, err := r.DeleteSQL(sqlTable(bqTable), ids, cf.Schema)
+	if err != nil {
+		log.Fatal(err)
+	}
+	timePartitioning, err := cf.Schema.TimePartitioning()
+	if err != nil {
+		log.Fatal(err)
+	}
+	staging, err := createStagingTable(ctx, dataset, stagingTableName(cf, "backfill"), cf.Schema.BigQuerySchema(), timePartitioning)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dropStagingTable(ctx, staging)
+
+	var client *storage.Client
+	if cf.StagingMethod() == StagingGCS || strings.HasPrefix(cf.Rejects, "gs://") {
+		client, err = storage.NewClient(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	st, err := newStager(cf, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rejects, err := newRejectSink(ctx, cf, client, st, dataset)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = streamAndLoad(ctx, cf, datasetID, where, token, st, rejects, staging, quiet, socrataCount, nil)
+	if rejects != nil {
+		if rerr := rejects.Close(ctx); err == nil {
+			err = rerr
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := runQuery(ctx, bqclient, backfillSQL(deleteSQL, sqlTable(bqTable), sqlTable(staging), cf.Schema)); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Replaced the matching rows in %s with %s\n", bqTable.TableID, staging.TableID)
+	fmt.Printf("Backfill Complete\n")
+}
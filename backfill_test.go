@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestParseBackfillBound(t *testing.T) {
+	tests := []struct {
+		value  string
+		end    bool
+		expect string
+		err    bool
+	}{
+		{"", false, "0001-01-01T00:00:00Z", false},
+		{"2024-03-09", false, "2024-03-09T00:00:00Z", false},
+		{"2024-03-11", true, "2024-03-12T00:00:00Z", false},
+		{"2024-03-09T12:30:00Z", true, "2024-03-09T12:30:00Z", false},
+		{"2024-03-09T12:30:00-05:00", false, "2024-03-09T12:30:00-05:00", false},
+		{"03/09/2024", false, "", true},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := parseBackfillBound(tc.value, tc.end)
+			if tc.err != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err == nil && got.Format(time.RFC3339) != tc.expect {
+				t.Errorf("got %s expected %s", got.Format(time.RFC3339), tc.expect)
+			}
+		})
+	}
+}
+
+func TestBackfillRange(t *testing.T) {
+	partitioned := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_created_at": {SourceField: ":created_at", Type: bigquery.TimestampFieldType, Required: true, TimePartition: TimePartitionDay},
+	}
+	otherPartition := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_created_at": {SourceField: ":created_at", Type: bigquery.DateTimeFieldType},
+		"day":         {SourceField: "day", Type: bigquery.DateFieldType, Required: true, TimePartition: TimePartitionDay},
+	}
+	from := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		r         backfillRange
+		schema    TableSchema
+		soql      string
+		deleteSQL string
+		err       bool
+	}{
+		{
+			backfillRange{From: from, To: to}, partitioned,
+			":created_at >= '2024-03-09T00:00:00Z' AND :created_at < '2024-03-12T00:00:00Z'",
+			"DELETE FROM `p.d.t` WHERE `_created_at` >= TIMESTAMP '2024-03-09 00:00:00+00:00' AND `_created_at` < TIMESTAMP '2024-03-12 00:00:00+00:00'",
+			false,
+		},
+		{
+			backfillRange{From: from}, otherPartition,
+			":created_at >= '2024-03-09T00:00:00Z'",
+			"DELETE FROM `p.d.t` WHERE `_created_at` >= DATETIME '2024-03-09 00:00:00' AND `day` IS NOT NULL",
+			false,
+		},
+		{
+			backfillRange{Where: "borough = 'BK'"}, partitioned,
+			"(borough = 'BK')",
+			"DELETE FROM `p.d.t` WHERE `_created_at` IS NOT NULL AND _id IN (SELECT _id FROM `p.d.ids`)",
+			false,
+		},
+		{
+			backfillRange{To: to, Where: "a OR b"}, TableSchema{"_id": partitioned["_id"], "created": partitioned["_created_at"]},
+			":created_at < '2024-03-12T00:00:00Z' AND (a OR b)",
+			"DELETE FROM `p.d.t` WHERE `created` < TIMESTAMP '2024-03-12 00:00:00+00:00' AND _id IN (SELECT _id FROM `p.d.ids`)",
+			false,
+		},
+		{backfillRange{From: from}, TableSchema{"_id": partitioned["_id"]}, ":created_at >= '2024-03-09T00:00:00Z'", "", true},
+		{backfillRange{}, partitioned, "", "", true},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if got := tc.r.SoQL(); got != tc.soql {
+				t.Errorf("got SoQL %q expected %q", got, tc.soql)
+			}
+			got, err := tc.r.DeleteSQL("`p.d.t`", "`p.d.ids`", tc.schema)
+			if tc.err != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got != tc.deleteSQL {
+				t.Errorf("got %q expected %q", got, tc.deleteSQL)
+			}
+		})
+	}
+}
+
+func TestBackfillSQL(t *testing.T) {
+	ts := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_created_at": {SourceField: ":created_at", Type: bigquery.TimestampFieldType, Required: true},
+	}
+	got := backfillSQL("DELETE FROM `p.d.t` WHERE `_created_at` >= TIMESTAMP '2024-03-09 00:00:00+00:00'", "`p.d.t`", "`p.d.s`", ts)
+	expect := "BEGIN TRANSACTION;\n" +
+		"DELETE FROM `p.d.t` WHERE `_created_at` >= TIMESTAMP '2024-03-09 00:00:00+00:00';\n" +
+		"INSERT INTO `p.d.t` (`_created_at`, `_id`) SELECT `_created_at`, `_id` FROM `p.d.s`;\n" +
+		"COMMIT TRANSACTION;"
+	if got != expect {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expect)
+	}
+}
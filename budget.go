@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrorBudget is the maximum number ("100") or percentage ("0.5%") of invalid values
+// allowed in a sync. An empty budget is unlimited.
+type ErrorBudget string
+
+func (b ErrorBudget) parse() (limit float64, percent bool, err error) {
+	s := strings.TrimSpace(string(b))
+	if strings.HasSuffix(s, "%") {
+		percent = true
+		s = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+	}
+	limit, err = strconv.ParseFloat(s, 64)
+	if err != nil || limit < 0 || (!percent && limit != float64(int64(limit))) {
+		return 0, false, fmt.Errorf("invalid error budget %q (expected a count like \"100\" or a percentage like \"0.5%%\")", string(b))
+	}
+	return limit, percent, nil
+}
+
+func (b ErrorBudget) Validate() error {
+	if b == "" {
+		return nil
+	}
+	_, _, err := b.parse()
+	return err
+}
+
+// Exceeded reports whether errors out of rows is over the budget
+func (b ErrorBudget) Exceeded(errors, rows int64) (bool, error) {
+	if b == "" || errors == 0 {
+		return false, nil
+	}
+	limit, percent, err := b.parse()
+	if err != nil {
+		return false, err
+	}
+	if percent {
+		return float64(errors)*100 > limit*float64(rows), nil
+	}
+	return float64(errors) > limit, nil
+}
+
+// IsCount reports whether the budget is a count, which can be enforced as soon as it is exceeded
+func (b ErrorBudget) IsCount() bool {
+	return b != "" && !strings.HasSuffix(strings.TrimSpace(string(b)), "%")
+}
+
+// checkErrorBudgets returns an error if the invalid values seen so far exceed the
+// per-field max_errors or the per-run MaxErrors budget. When countsOnly is set
+// percentage budgets are skipped because they can't be judged until more rows are seen.
+func checkErrorBudgets(cf ConfigFile, s *transformStats, countsOnly bool) error {
+	var names []string
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		budget := cf.Schema[name].MaxErrors
+		if countsOnly && !budget.IsCount() {
+			continue
+		}
+		errors := s.Fields[name].Errors
+		if exceeded, err := budget.Exceeded(errors, s.Rows); err != nil {
+			return fmt.Errorf("field %q %w", name, err)
+		} else if exceeded {
+			return fmt.Errorf("error budget exceeded: field %q has %d invalid values in %d rows (max_errors %q)", name, errors, s.Rows, budget)
+		}
+	}
+	if countsOnly && !cf.MaxErrors.IsCount() {
+		return nil
+	}
+	if exceeded, err := cf.MaxErrors.Exceeded(s.InvalidRows, s.Rows); err != nil {
+		return fmt.Errorf("MaxErrors %w", err)
+	} else if exceeded {
+		return fmt.Errorf("error budget exceeded: %d of %d rows have invalid values (MaxErrors %q)", s.InvalidRows, s.Rows, cf.MaxErrors)
+	}
+	return nil
+}
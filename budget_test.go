@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestErrorBudgetExceeded(t *testing.T) {
+	tests := []struct {
+		budget       ErrorBudget
+		errors, rows int64
+		expect       bool
+		err          bool
+	}{
+		{"", 100, 100, false, false},
+		{"0", 0, 100, false, false},
+		{"0", 1, 100, true, false},
+		{"10", 10, 100, false, false},
+		{"10", 11, 100, true, false},
+		{"5%", 5, 100, false, false},
+		{"5%", 6, 100, true, false},
+		{"0.5%", 1, 1000, false, false},
+		{"0.5%", 6, 1000, true, false},
+		{" 1 % ", 2, 100, true, false},
+		{"1.5", 2, 100, false, true},
+		{"-1", 2, 100, false, true},
+		{"ten", 2, 100, false, true},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := tc.budget.Exceeded(tc.errors, tc.rows)
+			if tc.err != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got != tc.expect {
+				t.Errorf("%q with %d/%d got %v expected %v", tc.budget, tc.errors, tc.rows, got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestCheckErrorBudgets(t *testing.T) {
+	stats := transformStats{
+		Rows:        100,
+		InvalidRows: 8,
+		Fields: map[string]*fieldErrorStats{
+			"day":  {Errors: 5},
+			"time": {Errors: 3},
+		},
+	}
+	tests := []struct {
+		cf         ConfigFile
+		countsOnly bool
+		err        bool
+	}{
+		{ConfigFile{}, false, false},
+		{ConfigFile{Schema: TableSchema{"day": {MaxErrors: "5"}}}, false, false},
+		{ConfigFile{Schema: TableSchema{"day": {MaxErrors: "4"}}}, false, true},
+		{ConfigFile{Schema: TableSchema{"time": {MaxErrors: "2%"}}}, false, true},
+		{ConfigFile{Schema: TableSchema{"time": {MaxErrors: "2%"}}}, true, false},
+		{ConfigFile{Config: Config{MaxErrors: "8"}}, false, false},
+		{ConfigFile{Config: Config{MaxErrors: "7"}}, true, true},
+		{ConfigFile{Config: Config{MaxErrors: "5%"}}, false, true},
+		{ConfigFile{Config: Config{MaxErrors: "5%"}}, true, false},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			err := checkErrorBudgets(tc.cf, &stats, tc.countsOnly)
+			if tc.err != (err != nil) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
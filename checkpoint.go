@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Cursor identifies a position in a Socrata dataset ordered by :created_at, :id
+// or, when CreatedAt is empty, by :id alone
+type Cursor struct {
+	CreatedAt string `json:"created_at,omitempty"`
+	ID        string `json:"id"`
+}
+
+func (c Cursor) IsZero() bool {
+	return c.CreatedAt == "" && c.ID == ""
+}
+
+// Where returns a SoQL condition matching records after the cursor
+func (c Cursor) Where() string {
+	switch {
+	case c.IsZero():
+		return ""
+	case c.CreatedAt == "":
+		return fmt.Sprintf(":id > %s", soqlString(c.ID))
+	}
+	return fmt.Sprintf("(:created_at > %s OR (:created_at = %s AND :id > %s))", soqlString(c.CreatedAt), soqlString(c.CreatedAt), soqlString(c.ID))
+}
+
+func (c Cursor) String() string {
+	if c.CreatedAt == "" {
+		return ":id " + c.ID
+	}
+	return fmt.Sprintf(":created_at %s :id %s", c.CreatedAt, c.ID)
+}
+
+func soqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// andWhere joins SoQL (or SQL) conditions with AND, skipping empty ones
+func andWhere(conditions ...string) string {
+	var out []string
+	for _, c := range conditions {
+		if c != "" {
+			out = append(out, c)
+		}
+	}
+	return strings.Join(out, " AND ")
+}
+
+// Checkpoint records the progress of a chunked sync so an interrupted sync can resume
+type Checkpoint struct {
+	DatasetID string    `json:"dataset_id"`
+	Mode      SyncMode  `json:"mode"`
+	Table     string    `json:"table"` // the table chunks are loaded into
+	Where     string    `json:"where"` // the filter the sync started with
+	Cursor    Cursor    `json:"cursor"`
+	Rows      int64     `json:"rows"`
+	Updated   time.Time `json:"updated"`
+}
+
+// checkpointStore persists a Checkpoint to a local file or a gs://bucket/path object
+type checkpointStore struct {
+	path string
+	obj  *storage.ObjectHandle
+}
+
+func newCheckpointStore(client *storage.Client, path string) checkpointStore {
+	s := checkpointStore{path: path}
+	if strings.HasPrefix(path, "gs://") {
+		bucket, name, _ := strings.Cut(strings.TrimPrefix(path, "gs://"), "/")
+		s.obj = client.Bucket(bucket).Object(name)
+	}
+	return s
+}
+
+// Load returns the saved checkpoint or nil if there is none
+func (s checkpointStore) Load(ctx context.Context) (*Checkpoint, error) {
+	var r io.ReadCloser
+	var err error
+	if s.obj != nil {
+		r, err = s.obj.NewReader(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, nil
+		}
+	} else {
+		r, err = os.Open(s.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	var c Checkpoint
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("checkpoint %s %w", s.path, err)
+	}
+	return &c, nil
+}
+
+func (s checkpointStore) Save(ctx context.Context, c Checkpoint) error {
+	body, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if s.obj != nil {
+		w := s.obj.NewWriter(ctx)
+		w.ContentType = "application/json"
+		if _, err := w.Write(body); err != nil {
+			_ = w.Close()
+			return err
+		}
+		return w.Close()
+	}
+	// write to a temp file and rename so a crash never leaves a partial checkpoint
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+func (s checkpointStore) Remove(ctx context.Context) error {
+	if s.obj != nil {
+		err := s.obj.Delete(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil
+		}
+		return err
+	}
+	err := os.Remove(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// checkpointer tracks sync progress and saves it each time a chunk is committed
+type checkpointer struct {
+	store checkpointStore
+	state Checkpoint
+}
+
+// Cursor returns the position of the last committed record. It is safe to call on a nil checkpointer.
+func (c *checkpointer) Cursor() Cursor {
+	if c == nil {
+		return Cursor{}
+	}
+	return c.state.Cursor
+}
+
+// Commit records that rows through cursor have been loaded
+func (c *checkpointer) Commit(ctx context.Context, rows int64, cursor Cursor) error {
+	c.state.Rows += rows
+	c.state.Cursor = cursor
+	c.state.Updated = time.Now()
+	if err := c.store.Save(ctx, c.state); err != nil {
+		return err
+	}
+	fmt.Printf("> checkpoint %d rows through %s\n", c.state.Rows, cursor)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestCursorWhere(t *testing.T) {
+	tests := []struct {
+		cursor Cursor
+		expect string
+	}{
+		{Cursor{}, ""},
+		{Cursor{CreatedAt: "2024-01-02T03:04:05.000Z", ID: "row-abc"}, "(:created_at > '2024-01-02T03:04:05.000Z' OR (:created_at = '2024-01-02T03:04:05.000Z' AND :id > 'row-abc'))"},
+		{Cursor{CreatedAt: "x", ID: "it's"}, "(:created_at > 'x' OR (:created_at = 'x' AND :id > 'it''s'))"},
+		{Cursor{ID: "row-abc"}, ":id > 'row-abc'"},
+	}
+	for _, tc := range tests {
+		if got := tc.cursor.Where(); got != tc.expect {
+			t.Errorf("got %q expected %q", got, tc.expect)
+		}
+	}
+}
+
+func TestAndWhere(t *testing.T) {
+	if got := andWhere("", "a = 1", "", "b = 2"); got != "a = 1 AND b = 2" {
+		t.Fatalf("got %q", got)
+	}
+	if got := andWhere("", ""); got != "" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestCheckpointStore_Local(t *testing.T) {
+	ctx := context.Background()
+	s := newCheckpointStore(nil, filepath.Join(t.TempDir(), "test.toml.checkpoint.json"))
+
+	c, err := s.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != nil {
+		t.Fatalf("expected no checkpoint got %#v", c)
+	}
+
+	cp := &checkpointer{store: s, state: Checkpoint{DatasetID: "abcd-1234", Mode: SyncAppend, Table: "t"}}
+	if err := cp.Commit(ctx, 10, Cursor{CreatedAt: "2024-01-02T03:04:05.000Z", ID: "row-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Commit(ctx, 5, Cursor{CreatedAt: "2024-01-03T03:04:05.000Z", ID: "row-2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = s.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.Rows != 15 || c.Cursor.ID != "row-2" || c.DatasetID != "abcd-1234" {
+		t.Fatalf("unexpected checkpoint %#v", c)
+	}
+
+	if err := s.Remove(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = s.Load(ctx); c != nil {
+		t.Fatalf("expected checkpoint removed got %#v", c)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+type Function string
+
+const (
+	FunctionConcat   Function = "CONCAT"
+	FunctionDateTime Function = "DATETIME"
+	FunctionCoalesce Function = "COALESCE"
+	FunctionConstant Function = "CONSTANT"
+	FunctionLookup   Function = "LOOKUP"
+	FunctionLoadTime Function = "LOAD_TIME"
+)
+
+// loadTime is the value of LOAD_TIME fields, the same for every record loaded by this process
+var loadTime = time.Now().UTC().Truncate(time.Microsecond)
+
+// ComputedField represents a toml record which configures a column derived from the
+// converted schema fields rather than loaded from a Socrata column
+type ComputedField struct {
+	Function    Function           `comment:"CONCAT | DATETIME | COALESCE | CONSTANT | LOOKUP | LOAD_TIME" toml:"function"`
+	Type        bigquery.FieldType `toml:"bigquery_type"`
+	Description string             `toml:"description,omitempty"`
+	Required    bool               `toml:"required"`
+	OnError     OnError            `comment:"SKIP_VALUE | SKIP_ROW | ERROR " toml:"on_error,omitempty"`
+	Fields      []string           `comment:"the schema fields the function is applied to" toml:"fields,omitempty"`
+	Separator   string             `comment:"CONCAT separator" toml:"separator,omitempty"`
+	Value       string             `comment:"CONSTANT value" toml:"value,omitempty"`
+	Table       map[string]string  `comment:"LOOKUP values for each value of the field" toml:"table,omitempty"`
+	Timezone    string             `comment:"IANA time zone of a DATETIME function loaded into a TIMESTAMP; default UTC" toml:"timezone,omitempty"`
+}
+
+type ComputedSchema map[string]ComputedField
+
+// SchemaField returns the schema field the computed field is loaded into
+func (c ComputedField) SchemaField() SchemaField {
+	cp := c
+	return SchemaField{
+		Type:        c.Type,
+		Description: c.Description,
+		Required:    c.Required,
+		OnError:     c.OnError,
+		Computed:    &cp,
+	}
+}
+
+// addComputedFields adds the computed fields to the schema so they are converted, staged
+// and created in BigQuery with the fields loaded from Socrata
+func addComputedFields(s TableSchema, computed ComputedSchema) error {
+	for name, c := range computed {
+		if _, ok := s[name]; ok {
+			return fmt.Errorf("computed.%s is also a schema field", name)
+		}
+		s[name] = c.SchemaField()
+	}
+	return nil
+}
+
+// computedFields returns the names of the computed fields in s in the order they are evaluated
+func (t TableSchema) computedFields() []string {
+	var names []string
+	for name, f := range t {
+		if f.Computed != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Evaluate returns the value of the computed field for a converted record
+func (c ComputedField) Evaluate(r Record) (interface{}, error) {
+	switch c.Function {
+	case FunctionConcat:
+		var parts []string
+		for _, f := range c.Fields {
+			if r[f] != nil {
+				parts = append(parts, valueString(r[f]))
+			}
+		}
+		if len(parts) == 0 {
+			return nil, nil
+		}
+		return strings.Join(parts, c.Separator), nil
+	case FunctionDateTime:
+		if len(c.Fields) != 2 {
+			return nil, fmt.Errorf("DATETIME requires a DATE field and a TIME field")
+		}
+		date, clock := r[c.Fields[0]], r[c.Fields[1]]
+		if date == nil || clock == nil {
+			return nil, nil
+		}
+		loc, err := loadLocation(c.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		s := valueString(date) + "T" + valueString(clock)
+		if c.Type == bigquery.TimestampFieldType {
+			return ToTimestamp("", loc, s)
+		}
+		return ToDateTime("", loc, s)
+	case FunctionCoalesce:
+		for _, f := range c.Fields {
+			if r[f] != nil {
+				return r[f], nil
+			}
+		}
+		return nil, nil
+	case FunctionConstant:
+		return constantValue(c.Type, c.Value)
+	case FunctionLookup:
+		if len(c.Fields) != 1 {
+			return nil, fmt.Errorf("LOOKUP requires one field")
+		}
+		if r[c.Fields[0]] == nil {
+			return nil, nil
+		}
+		v, ok := c.Table[valueString(r[c.Fields[0]])]
+		if !ok {
+			return nil, nil
+		}
+		return constantValue(c.Type, v)
+	case FunctionLoadTime:
+		return constantValue(c.Type, loadTime.Format(time.RFC3339Nano))
+	}
+	return nil, fmt.Errorf("unknown function %q", c.Function)
+}
+
+// valueString formats a converted value for CONCAT, DATETIME and LOOKUP
+func valueString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// constantValue converts a CONSTANT, LOOKUP or LOAD_TIME value to the BigQuery type.
+// Dates are YYYY-MM-DD, times HH:MM:SS and timestamps RFC3339.
+func constantValue(t bigquery.FieldType, s string) (interface{}, error) {
+	switch t {
+	case bigquery.StringFieldType:
+		return s, nil
+	case bigquery.IntegerFieldType, bigquery.NumericFieldType, bigquery.BigNumericFieldType, bigquery.FloatFieldType:
+		return SchemaField{Type: t}.ConvertNumber(s)
+	case bigquery.BooleanFieldType:
+		return ToBoolean(s)
+	case bigquery.DateFieldType:
+		if len(s) > len("2006-01-02") {
+			s = s[:len("2006-01-02")]
+		}
+		return ToDate("2006-01-02", s)
+	case bigquery.TimeFieldType:
+		return ToTime("15:04:05", s)
+	case bigquery.TimestampFieldType:
+		return ToTimestamp("", time.UTC, s)
+	case bigquery.DateTimeFieldType:
+		return ToDateTime("", time.UTC, s)
+	}
+	return nil, fmt.Errorf("unsupported bigquery_type %s for a computed value", t)
+}
+
+// validateComputedField returns the problems with a computed field
+func validateComputedField(c ComputedField, s TableSchema) []string {
+	var errs []string
+	if c.Type == "" {
+		errs = append(errs, "bigquery_type must be set")
+	}
+	switch c.OnError {
+	case "", SkipValue, SkipRow, RaiseError:
+	default:
+		errs = append(errs, fmt.Sprintf("on_error must be one of SKIP_VALUE, SKIP_ROW, ERROR (got %q)", c.OnError))
+	}
+	fields := func(least, most int) {
+		switch {
+		case len(c.Fields) < least:
+			errs = append(errs, fmt.Sprintf("%s requires at least %d fields", c.Function, least))
+		case most > 0 && len(c.Fields) > most:
+			errs = append(errs, fmt.Sprintf("%s takes at most %d fields", c.Function, most))
+		}
+		for _, name := range c.Fields {
+			if f, ok := s[name]; !ok || f.Computed != nil {
+				errs = append(errs, fmt.Sprintf("field %q is not a schema field", name))
+			}
+		}
+	}
+	fieldType := func(i int) bigquery.FieldType {
+		if i < len(c.Fields) {
+			return s[c.Fields[i]].Type
+		}
+		return ""
+	}
+	switch c.Function {
+	case FunctionConcat:
+		fields(1, 0)
+		if c.Type != bigquery.StringFieldType {
+			errs = append(errs, fmt.Sprintf("CONCAT requires bigquery_type STRING (got %s)", c.Type))
+		}
+	case FunctionDateTime:
+		fields(2, 2)
+		if c.Type != bigquery.DateTimeFieldType && c.Type != bigquery.TimestampFieldType {
+			errs = append(errs, fmt.Sprintf("DATETIME requires bigquery_type DATETIME or TIMESTAMP (got %s)", c.Type))
+		}
+		if t := fieldType(0); t != "" && t != bigquery.DateFieldType {
+			errs = append(errs, fmt.Sprintf("DATETIME requires a DATE field then a TIME field (%q is %s)", c.Fields[0], t))
+		}
+		if t := fieldType(1); t != "" && t != bigquery.TimeFieldType {
+			errs = append(errs, fmt.Sprintf("DATETIME requires a DATE field then a TIME field (%q is %s)", c.Fields[1], t))
+		}
+		if _, err := loadLocation(c.Timezone); err != nil {
+			errs = append(errs, err.Error())
+		}
+	case FunctionCoalesce:
+		fields(1, 0)
+		for i, name := range c.Fields {
+			if t := fieldType(i); t != "" && t != c.Type {
+				errs = append(errs, fmt.Sprintf("COALESCE field %q is %s not %s", name, t, c.Type))
+			}
+		}
+	case FunctionConstant:
+		if _, err := constantValue(c.Type, c.Value); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %q %s", c.Value, err))
+		}
+	case FunctionLookup:
+		fields(1, 1)
+		if len(c.Table) == 0 {
+			errs = append(errs, "LOOKUP requires a table")
+		}
+		var keys []string
+		for k := range c.Table {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, err := constantValue(c.Type, c.Table[k]); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid table value %q for %q %s", c.Table[k], k, err))
+			}
+		}
+	case FunctionLoadTime:
+		switch c.Type {
+		case bigquery.TimestampFieldType, bigquery.DateTimeFieldType, bigquery.DateFieldType:
+		default:
+			errs = append(errs, fmt.Sprintf("LOAD_TIME requires bigquery_type TIMESTAMP, DATETIME or DATE (got %s)", c.Type))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("function must be one of CONCAT, DATETIME, COALESCE, CONSTANT, LOOKUP, LOAD_TIME (got %q)", c.Function))
+	}
+	return errs
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestComputedEvaluate(t *testing.T) {
+	r := Record{
+		"house":    "123",
+		"street":   "MAIN ST",
+		"unit":     nil,
+		"precinct": int64(75),
+		"day":      "2024-03-10",
+		"time":     "02:30:00",
+		"noon":     "12:00:00",
+		"a":        nil,
+		"b":        "x",
+	}
+	precincts := map[string]string{"75": "BROOKLYN", "1": "MANHATTAN"}
+	type testCase struct {
+		c      ComputedField
+		expect interface{}
+	}
+	tests := []testCase{
+		{ComputedField{Function: FunctionConcat, Type: bigquery.StringFieldType, Fields: []string{"house", "unit", "street"}, Separator: " "}, "123 MAIN ST"},
+		{ComputedField{Function: FunctionConcat, Type: bigquery.StringFieldType, Fields: []string{"unit", "a"}}, nil},
+		{ComputedField{Function: FunctionConcat, Type: bigquery.StringFieldType, Fields: []string{"precinct", "street"}, Separator: "-"}, "75-MAIN ST"},
+		{ComputedField{Function: FunctionDateTime, Type: bigquery.DateTimeFieldType, Fields: []string{"day", "noon"}}, "2024-03-10T12:00:00"},
+		{ComputedField{Function: FunctionDateTime, Type: bigquery.TimestampFieldType, Fields: []string{"day", "noon"}, Timezone: "America/New_York"}, "2024-03-10T16:00:00Z"},
+		// skipped by the start of daylight saving time
+		{ComputedField{Function: FunctionDateTime, Type: bigquery.TimestampFieldType, Fields: []string{"day", "time"}, Timezone: "America/New_York"}, "2024-03-10T07:30:00Z"},
+		{ComputedField{Function: FunctionDateTime, Type: bigquery.DateTimeFieldType, Fields: []string{"day", "unit"}}, nil},
+		{ComputedField{Function: FunctionCoalesce, Type: bigquery.StringFieldType, Fields: []string{"a", "unit", "b", "street"}}, "x"},
+		{ComputedField{Function: FunctionCoalesce, Type: bigquery.StringFieldType, Fields: []string{"a", "unit"}}, nil},
+		{ComputedField{Function: FunctionConstant, Type: bigquery.StringFieldType, Value: "nyc"}, "nyc"},
+		{ComputedField{Function: FunctionConstant, Type: bigquery.IntegerFieldType, Value: "2"}, int64(2)},
+		{ComputedField{Function: FunctionConstant, Type: bigquery.BooleanFieldType, Value: "true"}, true},
+		{ComputedField{Function: FunctionConstant, Type: bigquery.DateFieldType, Value: "2024-01-02"}, "2024-01-02"},
+		{ComputedField{Function: FunctionLookup, Type: bigquery.StringFieldType, Fields: []string{"precinct"}, Table: precincts}, "BROOKLYN"},
+		{ComputedField{Function: FunctionLookup, Type: bigquery.StringFieldType, Fields: []string{"house"}, Table: precincts}, nil},
+		{ComputedField{Function: FunctionLookup, Type: bigquery.StringFieldType, Fields: []string{"unit"}, Table: precincts}, nil},
+		{ComputedField{Function: FunctionLoadTime, Type: bigquery.TimestampFieldType}, loadTime.Format(time.RFC3339Nano)},
+		{ComputedField{Function: FunctionLoadTime, Type: bigquery.DateFieldType}, loadTime.Format("2006-01-02")},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := tc.c.Evaluate(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("got %#v expected %#v", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestTransformComputed(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.toml")
+	body := `
+[schema]
+
+  [schema.issue_date]
+    bigquery_type = "DATE"
+    source_field = "issue_date"
+    source_field_type = "text"
+    time_format = "01/02/2006"
+
+  [schema.violation_time]
+    bigquery_type = "TIME"
+    source_field = "violation_time"
+    source_field_type = "text"
+    time_format = "0304p"
+
+  [schema.precinct]
+    bigquery_type = "INTEGER"
+    source_field = "precinct"
+    source_field_type = "number"
+
+[computed]
+
+  [computed.violation_at]
+    bigquery_type = "DATETIME"
+    fields = ["issue_date", "violation_time"]
+    function = "DATETIME"
+
+  [computed.borough]
+    bigquery_type = "STRING"
+    fields = ["precinct"]
+    function = "LOOKUP"
+    required = true
+
+    [computed.borough.table]
+      75 = "BROOKLYN"
+
+  [computed._loaded_at]
+    bigquery_type = "TIMESTAMP"
+    function = "LOAD_TIME"
+`
+	if err := os.WriteFile(filename, []byte(body), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := LoadConfigFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := cf.Validate(); len(errs) != 4 {
+		// only the missing Dataset and BigQuery settings
+		t.Errorf("unexpected validation errors %v", errs)
+	}
+
+	var columns []string
+	for _, f := range cf.Schema.BigQuerySchema() {
+		columns = append(columns, fmt.Sprintf("%s %s %v", f.Name, f.Type, f.Required))
+	}
+	sort.Strings(columns)
+	expectColumns := []string{"_loaded_at TIMESTAMP false", "borough STRING true", "issue_date DATE false", "precinct INTEGER false", "violation_at DATETIME false", "violation_time TIME false"}
+	if !reflect.DeepEqual(columns, expectColumns) {
+		t.Errorf("got columns %q", columns)
+	}
+
+	got, errs, err := TransformRecord(Record{"issue_date": "03/06/2017", "violation_time": "0815P", "precinct": "75"}, cf.Schema)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("unexpected error %v %v", err, errs)
+	}
+	expect := Record{
+		"issue_date":     "2017-03-06",
+		"violation_time": "20:15:00",
+		"precinct":       int64(75),
+		"violation_at":   "2017-03-06T20:15:00",
+		"borough":        "BROOKLYN",
+		"_loaded_at":     loadTime.Format(time.RFC3339Nano),
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %#v expected %#v", got, expect)
+	}
+
+	// borough is required; a precinct missing from the table skips the row
+	got, errs, err = TransformRecord(Record{"issue_date": "03/06/2017", "precinct": "1"}, cf.Schema)
+	if err != nil || got != nil || len(errs) != 1 || errs[0].Field != "borough" {
+		t.Errorf("got %#v %v %v", got, errs, err)
+	}
+}
+
+func TestValidateComputed(t *testing.T) {
+	s := TableSchema{
+		"day":  {SourceField: "day", SourceFieldType: "text", Type: bigquery.DateFieldType, TimeFormat: TimeFormats{"2006-01-02"}},
+		"name": {SourceField: "name", SourceFieldType: "text", Type: bigquery.StringFieldType},
+	}
+	if err := addComputedFields(s, ComputedSchema{"name": {Function: FunctionConstant}}); err == nil {
+		t.Errorf("expected an error for a computed field with the name of a schema field")
+	}
+	type testCase struct {
+		c      ComputedField
+		expect []string
+	}
+	tests := []testCase{
+		{ComputedField{Function: FunctionConcat, Type: bigquery.StringFieldType, Fields: []string{"name", "missing"}}, []string{`field "missing" is not a schema field`}},
+		{ComputedField{Function: FunctionConcat, Type: bigquery.IntegerFieldType, Fields: []string{"name"}}, []string{"CONCAT requires bigquery_type STRING (got INTEGER)"}},
+		{ComputedField{Function: FunctionDateTime, Type: bigquery.DateTimeFieldType, Fields: []string{"day", "name"}, Timezone: "Nowhere"}, []string{`DATETIME requires a DATE field then a TIME field ("name" is STRING)`, `invalid timezone "Nowhere"`}},
+		{ComputedField{Function: FunctionCoalesce, Type: bigquery.StringFieldType, Fields: []string{"day", "name"}}, []string{`COALESCE field "day" is DATE not STRING`}},
+		{ComputedField{Function: FunctionConstant, Type: bigquery.IntegerFieldType, Value: "one"}, []string{`invalid value "one" invalid number "one"`}},
+		{ComputedField{Function: FunctionLookup, Type: bigquery.StringFieldType, Fields: []string{"name", "day"}}, []string{"LOOKUP takes at most 1 fields", "LOOKUP requires a table"}},
+		{ComputedField{Function: FunctionLoadTime, Type: bigquery.StringFieldType, OnError: "IGNORE"}, []string{`on_error must be one of SKIP_VALUE, SKIP_ROW, ERROR (got "IGNORE")`, "LOAD_TIME requires bigquery_type TIMESTAMP, DATETIME or DATE (got STRING)"}},
+		{ComputedField{Function: "UPPER", Type: bigquery.StringFieldType}, []string{`function must be one of CONCAT, DATETIME, COALESCE, CONSTANT, LOOKUP, LOAD_TIME (got "UPPER")`}},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got := validateComputedField(tc.c, s)
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("got %q expected %q", got, tc.expect)
+			}
+		})
+	}
+}
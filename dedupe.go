@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func dedupeCmd(args []string) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s dedupe", os.Args[0]), flag.ExitOnError)
+	dryRun := flagSet.Bool("dry-run", false, "report duplicate _id values without removing them")
+	if err := flagSet.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flagSet.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "missing filename")
+		os.Exit(1)
+	}
+	for _, configFile := range flagSet.Args() {
+		dedupeOne(configFile, *dryRun)
+	}
+}
+
+// dedupeOne removes rows with a duplicate _id from the BigQuery table, keeping one row for each _id
+func dedupeOne(configFile string, dryRun bool) {
+	cf, err := LoadConfigFile(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, ok := cf.Schema["_id"]; !ok {
+		log.Fatalf("dedupe requires schema field %q", "_id")
+	}
+	ctx := context.Background()
+	bqclient, err := bigquery.NewClient(ctx, cf.BigQuery.ProjectID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = bqclient.Close() }()
+	target := cf.BigQuery.SQLTableName()
+
+	it, err := bqclient.Query(duplicateCountSQL(target, cf.Schema)).Read(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var r struct {
+		IDs   int64
+		Extra int64
+	}
+	if err := it.Next(&r); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d duplicate _id values (%d extra rows)\n", target, r.IDs, r.Extra)
+	if r.IDs == 0 || dryRun {
+		return
+	}
+	if _, err := runQuery(ctx, bqclient, dedupeSQL(target, cf.Schema)); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Removed %d duplicate rows from %s\n", r.Extra, target)
+}
+
+// duplicateCountSQL counts the _id values which appear more than once and the rows beyond the first
+func duplicateCountSQL(target string, s TableSchema) string {
+	where := s.PartitionWhereClause()
+	if where != "" {
+		where = " " + where
+	}
+	return fmt.Sprintf("SELECT COUNT(*) AS ids, IFNULL(SUM(n - 1), 0) AS extra FROM (SELECT _id, COUNT(*) AS n FROM %s%s GROUP BY _id HAVING n > 1)", target, where)
+}
+
+// dedupeSQL builds a transaction which replaces every set of rows sharing an _id with
+// a single row; the most recently updated when the schema has _updated_at
+func dedupeSQL(target string, s TableSchema) string {
+	order := ""
+	if _, ok := s["_updated_at"]; ok {
+		order = " ORDER BY t._updated_at DESC"
+	}
+	filter := ""
+	and := ""
+	if f := s.PartitionFilter(); f != "" {
+		filter = " WHERE t." + f
+		and = " AND " + f
+	}
+	return fmt.Sprintf(`BEGIN TRANSACTION;
+CREATE TEMP TABLE deduped AS SELECT row.* FROM (SELECT ARRAY_AGG(t%s LIMIT 1)[OFFSET(0)] AS row FROM %s t%s GROUP BY t._id HAVING COUNT(*) > 1);
+DELETE FROM %s WHERE _id IN (SELECT _id FROM deduped)%s;
+INSERT INTO %s SELECT * FROM deduped;
+COMMIT TRANSACTION;`, order, target, filter, target, and, target)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestDedupeSQL(t *testing.T) {
+	ts := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_created_at": {SourceField: ":created_at", Type: bigquery.TimestampFieldType, Required: true, TimePartition: TimePartitionDay},
+		"_updated_at": {SourceField: ":updated_at", Type: bigquery.TimestampFieldType},
+	}
+	got := dedupeSQL("`p.d.t`", ts)
+	expect := "BEGIN TRANSACTION;\n" +
+		"CREATE TEMP TABLE deduped AS SELECT row.* FROM (SELECT ARRAY_AGG(t ORDER BY t._updated_at DESC LIMIT 1)[OFFSET(0)] AS row FROM `p.d.t` t WHERE t.`_created_at` IS NOT NULL GROUP BY t._id HAVING COUNT(*) > 1);\n" +
+		"DELETE FROM `p.d.t` WHERE _id IN (SELECT _id FROM deduped) AND `_created_at` IS NOT NULL;\n" +
+		"INSERT INTO `p.d.t` SELECT * FROM deduped;\n" +
+		"COMMIT TRANSACTION;"
+	if got != expect {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expect)
+	}
+
+	got = dedupeSQL("`p.d.t`", TableSchema{"_id": ts["_id"]})
+	expect = "BEGIN TRANSACTION;\n" +
+		"CREATE TEMP TABLE deduped AS SELECT row.* FROM (SELECT ARRAY_AGG(t LIMIT 1)[OFFSET(0)] AS row FROM `p.d.t` t GROUP BY t._id HAVING COUNT(*) > 1);\n" +
+		"DELETE FROM `p.d.t` WHERE _id IN (SELECT _id FROM deduped);\n" +
+		"INSERT INTO `p.d.t` SELECT * FROM deduped;\n" +
+		"COMMIT TRANSACTION;"
+	if got != expect {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expect)
+	}
+}
+
+func TestDuplicateCountSQL(t *testing.T) {
+	got := duplicateCountSQL("`p.d.t`", TableSchema{})
+	expect := "SELECT COUNT(*) AS ids, IFNULL(SUM(n - 1), 0) AS extra FROM (SELECT _id, COUNT(*) AS n FROM `p.d.t` GROUP BY _id HAVING n > 1)"
+	if got != expect {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expect)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+)
+
+// SchemaDiff is the drift report for one config file
+type SchemaDiff struct {
+	Config       string         `json:"config"`
+	DatasetID    string         `json:"dataset_id"`
+	Table        string         `json:"table"`
+	TableExists  bool           `json:"table_exists"`
+	Changes      []SchemaChange `json:"changes"`
+	Incompatible bool           `json:"incompatible"`
+}
+
+func diffSchemaCmd(args []string) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s diff-schema", os.Args[0]), flag.ExitOnError)
+	token := flagSet.String("socrata-app-token", "", "Socrata App Token (also src SOCRATA_APP_TOKEN env)")
+	retries := flagSet.Int("socrata-retries", DefaultRetryPolicy.MaxRetries, "number of times to retry failed Socrata API requests")
+	jsonOutput := flagSet.Bool("json", false, "output JSON")
+	if err := flagSet.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	socrataRetry.MaxRetries = *retries
+	if *token == "" {
+		*token = os.Getenv("SOCRATA_APP_TOKEN")
+	}
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "missing --socrata-app-token or environment variable SOCRATA_APP_TOKEN")
+		os.Exit(1)
+	}
+
+	if flagSet.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "missing filename")
+		os.Exit(1)
+	}
+	var diffs []SchemaDiff
+	var incompatible bool
+	for _, configFile := range flagSet.Args() {
+		d, err := diffSchemaOne(context.Background(), configFile, *token)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !*jsonOutput {
+			printSchemaDiff(d)
+		}
+		diffs = append(diffs, d)
+		incompatible = incompatible || d.Incompatible
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diffs); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if incompatible {
+		os.Exit(1)
+	}
+}
+
+func diffSchemaOne(ctx context.Context, configFile, token string) (SchemaDiff, error) {
+	cf, err := LoadConfigFile(configFile)
+	if err != nil {
+		return SchemaDiff{}, err
+	}
+	d := SchemaDiff{Config: configFile, DatasetID: cf.DatasetID(), Table: cf.BigQuery.SQLTableName()}
+
+	md, err := FetchMetadata(ctx, cf.APIBase(), d.DatasetID, token)
+	if err != nil {
+		return d, err
+	}
+
+	bqclient, err := bigquery.NewClient(ctx, cf.BigQuery.ProjectID)
+	if err != nil {
+		return d, err
+	}
+	defer func() { _ = bqclient.Close() }()
+	var schema bigquery.Schema
+	tmd, err := bqclient.Dataset(cf.BigQuery.DatasetName).Table(cf.BigQuery.TableName).Metadata(ctx)
+	var e *googleapi.Error
+	switch {
+	case errors.As(err, &e) && e.Code == http.StatusNotFound:
+		// sync will create the table from the config schema
+	case err != nil:
+		return d, err
+	default:
+		d.TableExists = true
+		schema = tmd.Schema
+	}
+
+	d.Changes = diffSchema(*md, cf.Schema, schema)
+	for _, c := range d.Changes {
+		d.Incompatible = d.Incompatible || c.Incompatible
+	}
+	return d, nil
+}
+
+func printSchemaDiff(d SchemaDiff) {
+	fmt.Printf("%s (Socrata %s, BigQuery %s)\n", d.Config, d.DatasetID, d.Table)
+	if !d.TableExists {
+		fmt.Printf("  BigQuery table does not exist\n")
+	}
+	if len(d.Changes) == 0 {
+		fmt.Printf("  no schema drift\n")
+		return
+	}
+	for _, c := range d.Changes {
+		fmt.Printf("  %-17s %s\n", c.Kind, c)
+	}
+}
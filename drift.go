@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	toml "github.com/pelletier/go-toml"
+)
+
+type DriftKind string
+
+const (
+	SocrataAdded     DriftKind = "socrata_added"     // a Socrata column not mapped in the config
+	SocrataRemoved   DriftKind = "socrata_removed"   // a config source_field no longer in Socrata
+	SocrataRetyped   DriftKind = "socrata_retyped"   // a Socrata column type differs from source_field_type
+	BigQueryMissing  DriftKind = "bigquery_missing"  // a config field missing from the BigQuery table
+	BigQueryExtra    DriftKind = "bigquery_extra"    // a BigQuery column not in the config
+	BigQueryRetyped  DriftKind = "bigquery_retyped"  // a BigQuery column type differs from bigquery_type
+	RequiredMismatch DriftKind = "required_mismatch" // the config and BigQuery disagree on REQUIRED
+)
+
+// SchemaChange is a single difference between the Socrata columns, the config schema and the BigQuery table
+type SchemaChange struct {
+	Kind         DriftKind `json:"kind"`
+	Field        string    `json:"field,omitempty"`
+	SourceField  string    `json:"source_field,omitempty"`
+	Socrata      string    `json:"socrata,omitempty"`
+	Config       string    `json:"config,omitempty"`
+	BigQuery     string    `json:"bigquery,omitempty"`
+	Incompatible bool      `json:"incompatible"`
+}
+
+func (c SchemaChange) String() string {
+	var s string
+	switch c.Kind {
+	case SocrataAdded:
+		s = fmt.Sprintf("Socrata column %q (%s) is not in the config", c.SourceField, c.Socrata)
+	case SocrataRemoved:
+		s = fmt.Sprintf("field %q source_field %q is not in Socrata", c.Field, c.SourceField)
+	case SocrataRetyped:
+		s = fmt.Sprintf("field %q source_field %q is %s in Socrata; config has %s", c.Field, c.SourceField, c.Socrata, c.Config)
+	case BigQueryMissing:
+		s = fmt.Sprintf("field %q (%s) is not in the BigQuery table", c.Field, c.Config)
+	case BigQueryExtra:
+		s = fmt.Sprintf("BigQuery column %q (%s) is not in the config", c.Field, c.BigQuery)
+	case BigQueryRetyped:
+		s = fmt.Sprintf("field %q is %s in BigQuery; config has %s", c.Field, c.BigQuery, c.Config)
+	case RequiredMismatch:
+		s = fmt.Sprintf("field %q is %s in BigQuery; config has %s", c.Field, c.BigQuery, c.Config)
+	default:
+		s = fmt.Sprintf("%s %q", c.Kind, c.Field)
+	}
+	if c.Incompatible {
+		s += " (incompatible)"
+	}
+	return s
+}
+
+func requiredMode(required bool) string {
+	if required {
+		return "REQUIRED"
+	}
+	return "NULLABLE"
+}
+
+// diffSchema compares the Socrata columns, the config schema and (when not nil) the
+// BigQuery table schema. Changes that will cause loads to fail or lose data are
+// marked Incompatible.
+func diffSchema(md SocrataMetadata, s TableSchema, bq bigquery.Schema) []SchemaChange {
+	var changes []SchemaChange
+
+	sourceFields := make(map[string]bool)
+	for _, f := range s {
+		sourceFields[f.SourceField] = true
+	}
+	columns := make(map[string]SocrataColumn)
+	for _, c := range md.Columns {
+		columns[c.FieldName] = c
+		if skipSocrataColumn(c) || sourceFields[c.FieldName] {
+			continue
+		}
+		changes = append(changes, SchemaChange{Kind: SocrataAdded, SourceField: c.FieldName, Socrata: c.DataTypeName})
+	}
+	for name, f := range s {
+		if strings.HasPrefix(f.SourceField, ":") || f.Computed != nil {
+			// system and computed fields are not listed in the column metadata
+			continue
+		}
+		c, ok := columns[f.SourceField]
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Kind: SocrataRemoved, Field: name, SourceField: f.SourceField, Config: f.SourceFieldType, Incompatible: f.Required})
+		case f.SourceFieldType != "" && c.DataTypeName != f.SourceFieldType:
+			changes = append(changes, SchemaChange{Kind: SocrataRetyped, Field: name, SourceField: f.SourceField, Socrata: c.DataTypeName, Config: f.SourceFieldType, Incompatible: true})
+		}
+	}
+
+	if bq != nil {
+		bqFields := make(map[string]*bigquery.FieldSchema)
+		for _, f := range bq {
+			bqFields[f.Name] = f
+			if _, ok := s[f.Name]; ok || f.Name == deletedAtField {
+				continue
+			}
+			// a required column can't be populated by loads which don't include it
+			changes = append(changes, SchemaChange{Kind: BigQueryExtra, Field: f.Name, BigQuery: string(f.Type), Incompatible: f.Required})
+		}
+		for name, f := range s {
+			b, ok := bqFields[name]
+			switch {
+			case !ok:
+				changes = append(changes, SchemaChange{Kind: BigQueryMissing, Field: name, SourceField: f.SourceField, Config: string(f.Type), Incompatible: true})
+			case b.Type != f.Type:
+				changes = append(changes, SchemaChange{Kind: BigQueryRetyped, Field: name, SourceField: f.SourceField, Config: string(f.Type), BigQuery: string(b.Type), Incompatible: true})
+			case b.Required != f.Required:
+				// loading null values into a REQUIRED column fails; the reverse is harmless
+				changes = append(changes, SchemaChange{Kind: RequiredMismatch, Field: name, SourceField: f.SourceField, Config: requiredMode(f.Required), BigQuery: requiredMode(b.Required), Incompatible: b.Required})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		if changes[i].Field != changes[j].Field {
+			return changes[i].Field < changes[j].Field
+		}
+		return changes[i].SourceField < changes[j].SourceField
+	})
+	return changes
+}
+
+// newColumnFields returns schema fields for the Socrata columns reported as SocrataAdded.
+// Columns with a Socrata type that can't be mapped are skipped.
+func newColumnFields(md SocrataMetadata, s TableSchema, changes []SchemaChange) TableSchema {
+	columns := make(map[string]SocrataColumn)
+	for _, c := range md.Columns {
+		columns[c.FieldName] = c
+	}
+	out := make(TableSchema)
+	for _, change := range changes {
+		if change.Kind != SocrataAdded {
+			continue
+		}
+		c := columns[change.SourceField]
+		f, err := NewSchemaField(c, "")
+		if err != nil {
+			fmt.Printf("> skipping new column %q: %s\n", c.FieldName, err)
+			continue
+		}
+		if _, ok := s[c.FieldName]; ok {
+			fmt.Printf("> skipping new column %q: schema field already exists\n", c.FieldName)
+			continue
+		}
+		out[c.FieldName] = f
+	}
+	return out
+}
+
+// appendSchemaFields adds fields to the [schema] section of a config file. New tables
+// are appended to the end of the file so existing formatting and comments are kept.
+func appendSchemaFields(filename string, fields TableSchema) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]TableSchema{"schema": fields}); err != nil {
+		return err
+	}
+	// [schema] is already defined in the file; only the sub-tables are appended
+	body := strings.Replace(buf.String(), "[schema]\n", "", 1)
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString("\n" + strings.TrimLeft(body, "\n")); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// addBigQueryColumns appends nullable columns to the table schema
+func addBigQueryColumns(ctx context.Context, bqTable *bigquery.Table, tmd *bigquery.TableMetadata, fields bigquery.Schema) (*bigquery.TableMetadata, error) {
+	for _, f := range fields {
+		fmt.Printf("Adding column %s (%s) to %s\n", f.Name, f.Type, tmd.FullID)
+	}
+	schema := append(append(bigquery.Schema{}, tmd.Schema...), fields...)
+	return bqTable.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, tmd.ETag)
+}
+
+// evolveSchema reports drift between Socrata, the config and the BigQuery table. When
+// AddNewColumns is set new Socrata columns are added to the config file, and nullable
+// config fields missing from the table are added to it.
+func evolveSchema(ctx context.Context, cf *ConfigFile, md SocrataMetadata, bqTable *bigquery.Table, tmd *bigquery.TableMetadata) (*bigquery.TableMetadata, error) {
+	changes := diffSchema(md, cf.Schema, tmd.Schema)
+	for _, c := range changes {
+		fmt.Printf("Schema drift: %s\n", c)
+	}
+	if !cf.AddNewColumns || len(changes) == 0 {
+		return tmd, nil
+	}
+
+	if added := newColumnFields(md, cf.Schema, changes); len(added) > 0 {
+		if err := appendSchemaFields(cf.filename, added); err != nil {
+			return tmd, err
+		}
+		for name, f := range added {
+			fmt.Printf("Added field %q (%s) to %s\n", name, f.Type, cf.filename)
+			cf.Schema[name] = f
+		}
+		changes = diffSchema(md, cf.Schema, tmd.Schema)
+	}
+
+	var missing bigquery.Schema
+	for _, c := range changes {
+		if c.Kind != BigQueryMissing || cf.Schema[c.Field].Required {
+			continue
+		}
+		f := cf.Schema[c.Field]
+		missing = append(missing, &bigquery.FieldSchema{Name: c.Field, Description: f.Description, Type: f.Type})
+	}
+	if len(missing) == 0 {
+		return tmd, nil
+	}
+	return addBigQueryColumns(ctx, bqTable, tmd, missing)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestDiffSchema(t *testing.T) {
+	md := SocrataMetadata{Columns: []SocrataColumn{
+		{FieldName: "name", DataTypeName: "text"},
+		{FieldName: "amount", DataTypeName: "text"},
+		{FieldName: "added", DataTypeName: "number"},
+		{FieldName: ":@computed_region", DataTypeName: "meta_data"},
+	}}
+	s := TableSchema{
+		"_id":     {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"name":    {SourceField: "name", SourceFieldType: "text", Type: bigquery.StringFieldType},
+		"amount":  {SourceField: "amount", SourceFieldType: "number", Type: bigquery.NumericFieldType},
+		"removed": {SourceField: "removed", SourceFieldType: "text", Type: bigquery.StringFieldType},
+		"status":  {SourceField: "name", Type: bigquery.StringFieldType, Required: true},
+	}
+	bq := bigquery.Schema{
+		{Name: "_id", Type: bigquery.StringFieldType, Required: true},
+		{Name: "name", Type: bigquery.IntegerFieldType},
+		{Name: "amount", Type: bigquery.NumericFieldType},
+		{Name: "status", Type: bigquery.StringFieldType},
+		{Name: "legacy", Type: bigquery.StringFieldType, Required: true},
+		{Name: deletedAtField, Type: bigquery.TimestampFieldType},
+	}
+
+	got := diffSchema(md, s, bq)
+	expect := []SchemaChange{
+		{Kind: BigQueryExtra, Field: "legacy", BigQuery: "STRING", Incompatible: true},
+		{Kind: BigQueryMissing, Field: "removed", SourceField: "removed", Config: "STRING", Incompatible: true},
+		{Kind: BigQueryRetyped, Field: "name", SourceField: "name", Config: "STRING", BigQuery: "INTEGER", Incompatible: true},
+		{Kind: RequiredMismatch, Field: "status", SourceField: "name", Config: "REQUIRED", BigQuery: "NULLABLE"},
+		{Kind: SocrataAdded, SourceField: "added", Socrata: "number"},
+		{Kind: SocrataRemoved, Field: "removed", SourceField: "removed", Config: "text"},
+		{Kind: SocrataRetyped, Field: "amount", SourceField: "amount", Socrata: "text", Config: "number", Incompatible: true},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got\n%#v\nexpected\n%#v", got, expect)
+	}
+
+	added := newColumnFields(md, s, got)
+	if f, ok := added["added"]; !ok || f.Type != bigquery.NumericFieldType || f.Required || len(added) != 1 {
+		t.Errorf("unexpected new columns %#v", added)
+	}
+}
+
+func TestAppendSchemaFields(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.toml")
+	body := `# hand edited
+Dataset = "https://data.example.com/d/abcd-1234"
+
+[BigQuery]
+  TableName = "example"
+
+[schema]
+
+  [schema._id]
+    bigquery_type = "STRING"
+    required = true
+    source_field = ":id"
+`
+	if err := os.WriteFile(filename, []byte(body), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err := appendSchemaFields(filename, TableSchema{
+		"added": {SourceField: "added", SourceFieldType: "number", Type: bigquery.NumericFieldType},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := LoadConfigFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.BigQuery.TableName != "example" || len(cf.Schema) != 2 {
+		t.Fatalf("unexpected config %#v", cf)
+	}
+	if f := cf.Schema["added"]; f.SourceField != "added" || f.Type != bigquery.NumericFieldType {
+		t.Fatalf("unexpected field %#v", f)
+	}
+}
+
+func TestSchemaChangeString(t *testing.T) {
+	tests := []struct {
+		c      SchemaChange
+		expect string
+	}{
+		{SchemaChange{Kind: SocrataAdded, SourceField: "added", Socrata: "number"}, `Socrata column "added" (number) is not in the config`},
+		{SchemaChange{Kind: BigQueryRetyped, Field: "name", Config: "STRING", BigQuery: "INTEGER", Incompatible: true}, `field "name" is INTEGER in BigQuery; config has STRING (incompatible)`},
+		{SchemaChange{Kind: RequiredMismatch, Field: "status", Config: "REQUIRED", BigQuery: "NULLABLE"}, `field "status" is NULLABLE in BigQuery; config has REQUIRED`},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if got := tc.c.String(); got != tc.expect {
+				t.Fatalf("got %q expected %q", got, tc.expect)
+			}
+		})
+	}
+}
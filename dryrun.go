@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// transformStats summarizes how a set of records were (or would be) transformed
+type transformStats struct {
+	Rows        int64
+	Loaded      int64
+	InvalidRows int64 // rows with at least one invalid value
+	SkippedRows int64
+	FailedRows  int64        // rows which stop the sync with on_error = ERROR
+	Skipped     []skippedRow // the first maxListedRows skipped rows
+	Failed      []skippedRow
+	Fields      map[string]*fieldErrorStats
+}
+
+const maxListedRows = 20
+
+type skippedRow struct {
+	Row    int64
+	ID     string
+	Fields []string
+}
+
+type fieldErrorStats struct {
+	SourceField string
+	OnError     OnError
+	Errors      int64
+	Example     string
+}
+
+// Add records the result of TransformRecord for a row
+func (s *transformStats) Add(row Record, out Record, errs []FieldError, err error) {
+	s.Rows++
+	if s.Fields == nil {
+		s.Fields = make(map[string]*fieldErrorStats)
+	}
+	var fields []string
+	for _, fe := range errs {
+		f, ok := s.Fields[fe.Field]
+		if !ok {
+			f = &fieldErrorStats{SourceField: fe.SourceField, OnError: fe.Policy(), Example: fe.Error()}
+			s.Fields[fe.Field] = f
+		}
+		f.Errors++
+		fields = append(fields, fe.Field)
+	}
+	sort.Strings(fields)
+	if len(errs) > 0 {
+		s.InvalidRows++
+	}
+	id, _ := row[":id"].(string)
+	switch {
+	case err != nil:
+		s.FailedRows++
+		if len(s.Failed) < maxListedRows {
+			s.Failed = append(s.Failed, skippedRow{Row: s.Rows, ID: id, Fields: fields})
+		}
+	case out == nil:
+		s.SkippedRows++
+		if len(s.Skipped) < maxListedRows {
+			s.Skipped = append(s.Skipped, skippedRow{Row: s.Rows, ID: id, Fields: fields})
+		}
+	default:
+		s.Loaded++
+	}
+}
+
+func (s *transformStats) Print(w io.Writer) {
+	fmt.Fprintf(w, "Transformed %d rows: %d loaded, %d skipped, %d errors\n", s.Rows, s.Loaded, s.SkippedRows, s.FailedRows)
+	if len(s.Fields) == 0 {
+		fmt.Fprintf(w, "No invalid values\n")
+		return
+	}
+
+	var names []string
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "\nInvalid values by field:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "  field\tsource_field\ton_error\terrors\texample\n")
+	for _, name := range names {
+		f := s.Fields[name]
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%d\t%s\n", name, f.SourceField, f.OnError, f.Errors, f.Example)
+	}
+	_ = tw.Flush()
+
+	values := make(map[OnError]int64)
+	for _, f := range s.Fields {
+		values[f.OnError] += f.Errors
+	}
+	fmt.Fprintf(w, "\nBy on_error policy:\n")
+	fmt.Fprintf(w, "  %s: %d values set to null\n", SkipValue, values[SkipValue])
+	fmt.Fprintf(w, "  %s: %d rows skipped\n", SkipRow, s.SkippedRows)
+	printSkippedRows(w, s.Skipped, s.SkippedRows)
+	fmt.Fprintf(w, "  %s: %d rows stop the sync\n", RaiseError, s.FailedRows)
+	printSkippedRows(w, s.Failed, s.FailedRows)
+}
+
+func printSkippedRows(w io.Writer, rows []skippedRow, total int64) {
+	for _, r := range rows {
+		fmt.Fprintf(w, "    row %d :id %s (%s)\n", r.Row, r.ID, strings.Join(r.Fields, ", "))
+	}
+	if more := total - int64(len(rows)); more > 0 {
+		fmt.Fprintf(w, "    ... %d more\n", more)
+	}
+}
+
+// readRecords reads Socrata records saved as a JSON array or as newline delimited JSON
+func readRecords(r io.Reader, limit int64, handle func(Record) error) error {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			// an empty input has no records
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] == ' ' || b[0] == '\n' || b[0] == '\r' || b[0] == '\t' {
+			_, _ = br.ReadByte()
+			continue
+		}
+		if b[0] == '[' {
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+		}
+		break
+	}
+	var rows int64
+	for dec.More() && (limit <= 0 || rows < limit) {
+		var m Record
+		if err := dec.Decode(&m); err != nil {
+			return fmt.Errorf("row %d %w", rows+1, err)
+		}
+		rows++
+		if err := handle(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testTransformCmd(args []string) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s test-transform", os.Args[0]), flag.ExitOnError)
+	token := flagSet.String("socrata-app-token", "", "Socrata App Token (also src SOCRATA_APP_TOKEN env)")
+	retries := flagSet.Int("socrata-retries", DefaultRetryPolicy.MaxRetries, "number of times to retry failed Socrata API requests")
+	rows := flagSet.Int64("rows", 100, "number of sample rows")
+	input := flagSet.String("input", "", "read Socrata records from a saved JSON file instead of the Socrata API")
+	showRecords := flagSet.Bool("show-records", true, "print the transformed records")
+	if err := flagSet.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	socrataRetry.MaxRetries = *retries
+	if *token == "" {
+		*token = os.Getenv("SOCRATA_APP_TOKEN")
+	}
+	if *token == "" && *input == "" {
+		fmt.Fprintln(os.Stderr, "missing --socrata-app-token or environment variable SOCRATA_APP_TOKEN")
+		os.Exit(1)
+	}
+
+	if flagSet.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "missing filename")
+		os.Exit(1)
+	}
+	for _, configFile := range flagSet.Args() {
+		testTransformOne(configFile, *token, *input, *rows, *showRecords)
+	}
+}
+
+// testTransformOne runs sample records through TransformRecord and reports the
+// results without making any BigQuery or GCS calls
+func testTransformOne(configFile, token, input string, rows int64, showRecords bool) {
+	cf, err := LoadConfigFile(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Testing transform for %s\n", configFile)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	var stats transformStats
+	handle := func(row Record) error {
+		out, errs, err := TransformRecord(row, cf.Schema)
+		stats.Add(row, out, errs, err)
+		if showRecords && out != nil {
+			return enc.Encode(out)
+		}
+		return nil
+	}
+
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = readRecords(f, rows, handle)
+		_ = f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		sql := "SELECT :*, *"
+		if cf.BigQuery.WhereFilter != "" {
+			sql += " WHERE " + cf.BigQuery.WhereFilter
+		}
+		sql += fmt.Sprintf(" LIMIT %d", rows)
+		if err := StreamV3(context.Background(), cf.APIBase(), cf.DatasetID(), sql, token, handle); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Println()
+	stats.Print(os.Stdout)
+	if err := checkErrorBudgets(cf, &stats, false); err != nil {
+		fmt.Printf("\n%s\n", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestTransformRecord(t *testing.T) {
+	s := TableSchema{
+		"_id":  {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"day":  {SourceField: "day", SourceFieldType: "text", Type: bigquery.DateFieldType, TimeFormat: TimeFormats{"01/02/2006"}, OnError: SkipValue},
+		"time": {SourceField: "time", SourceFieldType: "text", Type: bigquery.TimeFieldType, TimeFormat: TimeFormats{"15:04"}, OnError: SkipRow},
+		"when": {SourceField: "when", SourceFieldType: "text", Type: bigquery.DateFieldType, TimeFormat: TimeFormats{"2006"}, OnError: RaiseError},
+	}
+	tests := []struct {
+		row        Record
+		expect     Record
+		errFields  []string
+		raiseError bool
+	}{
+		{Record{":id": "a", "day": "01/02/2024", "time": "13:45", "when": "2024"}, Record{"_id": "a", "day": "2024-01-02", "time": "13:45:00", "when": "2024-01-01"}, nil, false},
+		{Record{":id": "b", "day": "2024-01-02", "time": "13:45"}, Record{"_id": "b", "day": nil, "time": "13:45:00"}, []string{"day"}, false},
+		{Record{":id": "c", "day": "bad", "time": "bad"}, nil, []string{"day", "time"}, false},
+		{Record{":id": "d", "day": "01/02/2024", "time": "13:45", "when": "bad"}, nil, []string{"when"}, true},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, errs, err := TransformRecord(tc.row, s)
+			if tc.raiseError != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("got %#v expected %#v", got, tc.expect)
+			}
+			var fields []string
+			for _, fe := range errs {
+				fields = append(fields, fe.Field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, tc.errFields) {
+				t.Errorf("got error fields %v expected %v", fields, tc.errFields)
+			}
+		})
+	}
+}
+
+func TestTransformStats(t *testing.T) {
+	s := TableSchema{
+		"_id": {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"day": {SourceField: "day", SourceFieldType: "text", Type: bigquery.DateFieldType, TimeFormat: TimeFormats{"01/02/2006"}},
+	}
+	input := `[{":id": "a", "day": "01/02/2024"}, {":id": "b", "day": "2024-01-02"}, {":id": "c"}, {":id": "d", "day": "x"}]`
+	var stats transformStats
+	err := readRecords(strings.NewReader(input), 3, func(row Record) error {
+		out, errs, err := TransformRecord(row, s)
+		stats.Add(row, out, errs, err)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rows != 3 || stats.Loaded != 2 || stats.SkippedRows != 1 || stats.Skipped[0].ID != "b" {
+		t.Fatalf("unexpected stats %#v", stats)
+	}
+	if f := stats.Fields["day"]; f == nil || f.Errors != 1 || f.OnError != SkipRow {
+		t.Fatalf("unexpected field stats %#v", f)
+	}
+
+	var b bytes.Buffer
+	stats.Print(&b)
+	for _, expect := range []string{"Transformed 3 rows: 2 loaded, 1 skipped, 0 errors", "SKIP_ROW: 1 rows skipped", "row 2 :id b (day)"} {
+		if !strings.Contains(b.String(), expect) {
+			t.Errorf("missing %q in\n%s", expect, b.String())
+		}
+	}
+}
+
+func TestReadRecords_NDJSON(t *testing.T) {
+	var ids []string
+	err := readRecords(strings.NewReader("\n{\":id\": \"a\"}\n{\":id\": \"b\"}\n"), 0, func(row Record) error {
+		ids = append(ids, row[":id"].(string))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Fatalf("got %v", ids)
+	}
+}
+
+func TestReadRecords_Empty(t *testing.T) {
+	for i, input := range []string{"", "\n  \n"} {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var n int
+			err := readRecords(strings.NewReader(input), 0, func(row Record) error {
+				n++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 0 {
+				t.Fatalf("got %d records", n)
+			}
+		})
+	}
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,14 +17,17 @@ func initDataset(args []string) {
 	initFlagSet := flag.NewFlagSet(fmt.Sprintf("%s init", os.Args[0]), flag.ExitOnError)
 	apiEndpoint := initFlagSet.String("api-endpoint", "", "The URL to the socrata dataset")
 	token := initFlagSet.String("socrata-app-token", "", "Socrata App Token (also src SOCRATA_APP_TOKEN env)")
+	retries := initFlagSet.Int("socrata-retries", DefaultRetryPolicy.MaxRetries, "number of times to retry failed Socrata API requests")
 	debug := initFlagSet.Bool("debug", false, "show debug output")
 	dataDir := initFlagSet.String("data-dir", "", "directory to create config file in")
 	fn := initFlagSet.String("filename", "", "defaults to ${NAME}-${ID}.toml")
 	bqProject := initFlagSet.String("project-id", "", "Google Cloud Project ID")
 	bqDataset := initFlagSet.String("bq-dataset", "", "BigQuery Dataset")
+	sampleRows := initFlagSet.Int("sample-rows", 10000, "number of records sampled to infer column types (0 uses Socrata types only)")
 	if err := initFlagSet.Parse(args); err != nil {
 		log.Fatal(err)
 	}
+	socrataRetry.MaxRetries = *retries
 
 	if *apiEndpoint == "" {
 		fmt.Fprintln(os.Stderr, "missing --api-endpoint")
@@ -54,7 +57,7 @@ func initDataset(args []string) {
 		LogSocrataSchema(md.Columns)
 	}
 
-	examples, err := FetchExampleRecords(ctx, apiBase, datasetID, *token)
+	sample, err := FetchSampleRecords(ctx, apiBase, datasetID, *token, max(*sampleRows, 10))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -79,21 +82,15 @@ func initDataset(args []string) {
 	if err := encoder.Encode(c); err != nil {
 		log.Fatal(err)
 	}
-	if err := encoder.Encode(map[string]TableSchema{"schema": NewSchema(*md, ExampleRecords(examples))}); err != nil {
+	schema := NewSchema(*md, ExampleRecords(sample))
+	if *sampleRows > 0 {
+		profileSchema(schema, sample)
+	}
+	if err := encoder.Encode(map[string]TableSchema{"schema": schema}); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func FetchExampleRecords(ctx context.Context, apiBase *url.URL, datasetID, token string) ([]map[string]interface{}, error) {
-	fmt.Println("Fetching example records.")
-	var examples []map[string]interface{}
-	err := StreamV3(ctx, apiBase, datasetID, "SELECT :*, * LIMIT 10", token, func(row Record) error {
-		examples = append(examples, map[string]interface{}(row))
-		return nil
-	})
-	return examples, err
-}
-
 func ExampleRecords(data []map[string]interface{}) map[string]string {
 	buffer := make(map[string][]string)
 	for _, row := range data {
@@ -114,6 +111,11 @@ func ExampleRecords(data []map[string]interface{}) map[string]string {
 					buffer[k] = append(buffer[k], MustGeoJSON(ToGeoJSONPoint(v)).(string))
 				} else if _, ok := v["human_address"]; ok {
 					buffer[k] = append(buffer[k], MustGeoJSON(ToGeoJSONLocation(v)).(string))
+				} else if p, ok := v["phone_number"].(string); ok {
+					buffer[k] = append(buffer[k], fmt.Sprintf("%q", p))
+				} else if b, err := json.Marshal(v); err == nil {
+					// other geometries and document objects
+					buffer[k] = append(buffer[k], string(b))
 				} else {
 					log.Printf("unhandled type %T %#v", v, v)
 				}
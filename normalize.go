@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type Case string
+
+const (
+	UpperCase Case = "UPPER"
+	LowerCase Case = "LOWER"
+)
+
+var regexCache sync.Map
+
+// compileRegex returns the compiled regular expression, caching it for the next record
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q %w", pattern, err)
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// normalizes reports whether the field has any rules for Normalize to apply
+func (f SchemaField) normalizes() bool {
+	return f.Trim || f.Case != "" || f.RegexReplace != "" || f.RegexExtract != "" || len(f.NullValues) > 0 || len(f.ValueMap) > 0
+}
+
+// Normalize applies the field's rules to a text value before it is converted, in
+// order: trim, case, regex_replace, regex_extract, null_values and value_map. It
+// returns nil for a null value.
+func (f SchemaField) Normalize(s string) (interface{}, error) {
+	if f.Trim {
+		s = strings.TrimSpace(s)
+	}
+	switch f.Case {
+	case UpperCase:
+		s = strings.ToUpper(s)
+	case LowerCase:
+		s = strings.ToLower(s)
+	}
+	if f.RegexReplace != "" {
+		re, err := compileRegex(f.RegexReplace)
+		if err != nil {
+			return nil, err
+		}
+		s = re.ReplaceAllString(s, f.Replacement)
+	}
+	if f.RegexExtract != "" {
+		re, err := compileRegex(f.RegexExtract)
+		if err != nil {
+			return nil, err
+		}
+		m := re.FindStringSubmatch(s)
+		switch {
+		case m == nil:
+			return nil, fmt.Errorf("%q does not match regex_extract %q", s, f.RegexExtract)
+		case len(m) > 1:
+			s = m[1]
+		default:
+			s = m[0]
+		}
+	}
+	if slices.Contains(f.NullValues, s) {
+		return nil, nil
+	}
+	if v, ok := f.ValueMap[s]; ok {
+		s = v
+	}
+	return s, nil
+}
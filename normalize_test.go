@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pelletier/go-toml"
+)
+
+func TestNormalize(t *testing.T) {
+	borough := map[string]string{"BK": "BROOKLYN", "MN": "MANHATTAN"}
+	type testCase struct {
+		field  SchemaField
+		in     string
+		expect interface{}
+		err    bool
+	}
+	tests := []testCase{
+		{SchemaField{}, " a ", " a ", false},
+		{SchemaField{Trim: true}, " a ", "a", false},
+		{SchemaField{Case: UpperCase}, "Bk", "BK", false},
+		{SchemaField{Case: LowerCase}, "Bk", "bk", false},
+		{SchemaField{Trim: true, Case: UpperCase, ValueMap: borough}, " bk", "BROOKLYN", false},
+		{SchemaField{ValueMap: borough}, "BROOKLYN", "BROOKLYN", false},
+		{SchemaField{Trim: true, NullValues: []string{"N/A", ""}}, " N/A ", nil, false},
+		{SchemaField{Trim: true, NullValues: []string{"N/A", ""}}, "  ", nil, false},
+		{SchemaField{Case: UpperCase, NullValues: []string{"N/A"}}, "n/a", nil, false},
+		{SchemaField{RegexReplace: `\s+`, Replacement: " "}, "a  b\tc", "a b c", false},
+		{SchemaField{RegexReplace: `^(\d{5})-?\d{4}$`, Replacement: "$1"}, "10001-1234", "10001", false},
+		{SchemaField{RegexExtract: `(\d{5})`}, "New York, NY 10001", "10001", false},
+		{SchemaField{RegexExtract: `\d+`}, "Precinct 75", "75", false},
+		{SchemaField{RegexExtract: `\d+`}, "none", nil, true},
+		{SchemaField{RegexExtract: `(\d+)`, ValueMap: map[string]string{"75": "BROOKLYN"}}, "Precinct 75", "BROOKLYN", false},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := tc.field.Normalize(tc.in)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v", err)
+			}
+			if got != tc.expect {
+				t.Errorf("got %#v expected %#v", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestTransformNormalize(t *testing.T) {
+	s := TableSchema{
+		"active":  {SourceField: "active", SourceFieldType: "text", Type: bigquery.BooleanFieldType, Trim: true, NullValues: []string{"UNKNOWN"}},
+		"borough": {SourceField: "borough", SourceFieldType: "text", Type: bigquery.StringFieldType, Trim: true, Case: UpperCase, ValueMap: map[string]string{"BK": "BROOKLYN"}},
+		"count":   {SourceField: "count", SourceFieldType: "text", Type: bigquery.IntegerFieldType, RegexExtract: `(\d+)`, OnError: SkipValue},
+		"name":    {SourceField: "name", SourceFieldType: "text", Type: bigquery.StringFieldType, Required: true, NullValues: []string{"N/A"}, OnError: SkipRow},
+	}
+	type testCase struct {
+		in     Record
+		expect Record
+		errs   int
+	}
+	tests := []testCase{
+		{Record{"active": " Yes ", "borough": " bk ", "count": "about 12 units", "name": "a"}, Record{"active": true, "borough": "BROOKLYN", "count": int64(12), "name": "a"}, 0},
+		{Record{"active": "UNKNOWN", "borough": "Queens", "count": "none", "name": "b"}, Record{"active": nil, "borough": "QUEENS", "count": nil, "name": "b"}, 1},
+		{Record{"active": "N", "borough": nil, "count": nil, "name": "N/A"}, nil, 1},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, errs, err := TransformRecord(tc.in, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(errs) != tc.errs {
+				t.Errorf("got errors %v", errs)
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("got %#v expected %#v", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestNormalizeTOML(t *testing.T) {
+	body := `
+bigquery_type = "STRING"
+case = "UPPER"
+null_values = ["N/A", ""]
+source_field = "borough"
+trim = true
+
+[value_map]
+  BK = "BROOKLYN"
+  "STATEN IS" = "STATEN ISLAND"
+`
+	var f SchemaField
+	if err := toml.Unmarshal([]byte(body), &f); err != nil {
+		t.Fatal(err)
+	}
+	expect := SchemaField{
+		SourceField: "borough",
+		Type:        bigquery.StringFieldType,
+		Trim:        true,
+		Case:        UpperCase,
+		NullValues:  []string{"N/A", ""},
+		ValueMap:    map[string]string{"BK": "BROOKLYN", "STATEN IS": "STATEN ISLAND"},
+	}
+	if !reflect.DeepEqual(f, expect) {
+		t.Errorf("got %#v", f)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+type Rounding string
+
+const (
+	RoundTruncate Rounding = "TRUNCATE"
+	RoundHalfEven Rounding = "HALF_EVEN"
+)
+
+// numericLimits are the digits BigQuery allows before and after the decimal point
+var numericLimits = map[bigquery.FieldType]struct{ integer, scale int }{
+	bigquery.NumericFieldType:    {29, 9},
+	bigquery.BigNumericFieldType: {38, 38},
+}
+
+// fieldTypeAliases maps the GoogleSQL names of types to the names used by the BigQuery API
+var fieldTypeAliases = map[bigquery.FieldType]bigquery.FieldType{
+	"INT64":      bigquery.IntegerFieldType,
+	"FLOAT64":    bigquery.FloatFieldType,
+	"DECIMAL":    bigquery.NumericFieldType,
+	"BIGDECIMAL": bigquery.BigNumericFieldType,
+	"BOOL":       bigquery.BooleanFieldType,
+}
+
+// ConvertNumber converts a decimal string to the field's INTEGER, NUMERIC, BIGNUMERIC
+// or FLOAT type. Digits beyond the field's scale are removed according to its rounding,
+// and values outside the range of the type are an error.
+func (f SchemaField) ConvertNumber(s string) (interface{}, error) {
+	switch f.Type {
+	case bigquery.FloatFieldType:
+		if f.Scale != nil {
+			r, err := parseDecimal(s)
+			if err != nil {
+				return nil, err
+			}
+			s = roundDecimal(r, *f.Scale, f.Rounding).FloatString(*f.Scale)
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return nil, fmt.Errorf("invalid FLOAT %q", s)
+		}
+		return n, nil
+	case bigquery.IntegerFieldType:
+		r, err := parseDecimal(s)
+		if err != nil {
+			return nil, err
+		}
+		if !r.IsInt() && f.Rounding == "" {
+			return nil, fmt.Errorf("INTEGER %q is not a whole number (set rounding to convert it)", s)
+		}
+		n := roundDecimal(r, 0, f.Rounding).Num()
+		if !n.IsInt64() {
+			return nil, fmt.Errorf("INTEGER %q out of range", s)
+		}
+		return n.Int64(), nil
+	}
+	limits, ok := numericLimits[f.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported numeric type %s", f.Type)
+	}
+	r, err := parseDecimal(s)
+	if err != nil {
+		return nil, err
+	}
+	scale := limits.scale
+	if f.Scale != nil {
+		scale = *f.Scale
+	}
+	r = roundDecimal(r, scale, f.Rounding)
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(limits.integer)), nil)
+	if new(big.Int).Quo(r.Num(), r.Denom()).CmpAbs(limit) >= 0 {
+		return nil, fmt.Errorf("%s %q out of range (more than %d integer digits)", f.Type, s, limits.integer)
+	}
+	// keep the digits of the source value, i.e. "12.50" is not "12.5"
+	return r.FloatString(min(decimalPlaces(s), scale)), nil
+}
+
+// parseDecimal parses a decimal number (i.e. "-12.5" or "1.2e3")
+func parseDecimal(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return r, nil
+}
+
+// roundDecimal rounds r to scale decimal places, truncating toward zero unless rounding is HALF_EVEN
+func roundDecimal(r *big.Rat, scale int, rounding Rounding) *big.Rat {
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(factor))
+	n, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rounding == RoundHalfEven && rem.Sign() != 0 {
+		// compare twice the remainder with the denominator
+		c := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(scaled.Denom())
+		if c > 0 || c == 0 && n.Bit(0) == 1 {
+			n.Add(n, big.NewInt(int64(scaled.Num().Sign())))
+		}
+	}
+	return new(big.Rat).SetFrac(n, factor)
+}
+
+// decimalPlaces returns the number of digits after the decimal point in s
+func decimalPlaces(s string) int {
+	mantissa, exp, _ := strings.Cut(strings.ToLower(s), "e")
+	_, frac, _ := strings.Cut(mantissa, ".")
+	e, _ := strconv.Atoi(exp)
+	return max(len(frac)-e, 0)
+}
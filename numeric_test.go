@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pelletier/go-toml"
+)
+
+func TestConvertNumber(t *testing.T) {
+	scale := func(n int) *int { return &n }
+	type testCase struct {
+		field  SchemaField
+		in     string
+		expect interface{}
+		err    string
+	}
+	tests := []testCase{
+		{SchemaField{Type: bigquery.NumericFieldType}, "12.50", "12.50", ""},
+		{SchemaField{Type: bigquery.NumericFieldType}, "1.23456789019", "1.234567890", ""},
+		{SchemaField{Type: bigquery.NumericFieldType, Rounding: RoundHalfEven}, "1.23456789019", "1.234567890", ""},
+		{SchemaField{Type: bigquery.NumericFieldType, Scale: scale(2)}, "2.675", "2.67", ""},
+		{SchemaField{Type: bigquery.NumericFieldType, Scale: scale(2), Rounding: RoundHalfEven}, "2.675", "2.68", ""},
+		{SchemaField{Type: bigquery.NumericFieldType, Scale: scale(2), Rounding: RoundHalfEven}, "2.665", "2.66", ""},
+		{SchemaField{Type: bigquery.NumericFieldType, Scale: scale(0), Rounding: RoundHalfEven}, "-2.5", "-2", ""},
+		{SchemaField{Type: bigquery.NumericFieldType, Scale: scale(0), Rounding: RoundHalfEven}, "-3.5", "-4", ""},
+		{SchemaField{Type: bigquery.NumericFieldType}, "1.5e3", "1500", ""},
+		{SchemaField{Type: bigquery.NumericFieldType}, "99999999999999999999999999999.5", "99999999999999999999999999999.5", ""},
+		{SchemaField{Type: bigquery.NumericFieldType}, "100000000000000000000000000000", nil, `NUMERIC "100000000000000000000000000000" out of range (more than 29 integer digits)`},
+		{SchemaField{Type: bigquery.BigNumericFieldType}, "100000000000000000000000000000.123456789012", "100000000000000000000000000000.123456789012", ""},
+		{SchemaField{Type: bigquery.BigNumericFieldType}, "1e38", nil, `BIGNUMERIC "1e38" out of range (more than 38 integer digits)`},
+		{SchemaField{Type: bigquery.NumericFieldType}, "1/2", nil, `invalid number "1/2"`},
+		{SchemaField{Type: bigquery.NumericFieldType}, "abc", nil, `invalid number "abc"`},
+		{SchemaField{Type: bigquery.IntegerFieldType}, "-42", int64(-42), ""},
+		{SchemaField{Type: bigquery.IntegerFieldType}, "4.0", int64(4), ""},
+		{SchemaField{Type: bigquery.IntegerFieldType}, "4.5", nil, `INTEGER "4.5" is not a whole number (set rounding to convert it)`},
+		{SchemaField{Type: bigquery.IntegerFieldType, Rounding: RoundTruncate}, "-4.5", int64(-4), ""},
+		{SchemaField{Type: bigquery.IntegerFieldType, Rounding: RoundHalfEven}, "4.5", int64(4), ""},
+		{SchemaField{Type: bigquery.IntegerFieldType, Rounding: RoundHalfEven}, "5.5", int64(6), ""},
+		{SchemaField{Type: bigquery.IntegerFieldType}, "9223372036854775807", int64(9223372036854775807), ""},
+		{SchemaField{Type: bigquery.IntegerFieldType}, "9223372036854775808", nil, `INTEGER "9223372036854775808" out of range`},
+		{SchemaField{Type: bigquery.FloatFieldType}, "0.25", 0.25, ""},
+		{SchemaField{Type: bigquery.FloatFieldType, Scale: scale(1), Rounding: RoundHalfEven}, "0.25", 0.2, ""},
+		{SchemaField{Type: bigquery.FloatFieldType}, "1e400", nil, `invalid FLOAT "1e400"`},
+		{SchemaField{Type: bigquery.FloatFieldType}, "NaN", nil, `invalid FLOAT "NaN"`},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := tc.field.ConvertNumber(tc.in)
+			if err != nil || tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("got error %v expected %q", err, tc.err)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("got %#v expected %#v", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestNumericOnError(t *testing.T) {
+	s := TableSchema{
+		"count":  {SourceField: "count", SourceFieldType: "text", Type: bigquery.IntegerFieldType, OnError: SkipValue},
+		"amount": {SourceField: "amount", SourceFieldType: "number", Type: bigquery.NumericFieldType},
+	}
+	got, errs, err := TransformRecord(Record{"count": "1,000,000,000,000,000,000,000", "amount": "1,234.5"}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].Field != "count" {
+		t.Fatalf("got errors %v", errs)
+	}
+	expect := Record{"count": nil, "amount": "1234.5"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %#v expected %#v", got, expect)
+	}
+}
+
+func TestBigNumericParquet(t *testing.T) {
+	s := TableSchema{"value": {Type: bigquery.BigNumericFieldType}}
+	var b bytes.Buffer
+	w, err := newParquetWriter(&b, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(Record{"value": "-1.5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, columns := readParquet(t, b.Bytes())
+	expect := []interface{}{"-15" + fmt.Sprintf("%037d", 0)}
+	if !reflect.DeepEqual(columns["value"], expect) {
+		t.Errorf("got %#v expected %#v", columns["value"], expect)
+	}
+}
+
+func TestNumericTOML(t *testing.T) {
+	var f SchemaField
+	if err := toml.Unmarshal([]byte("bigquery_type = \"NUMERIC\"\nscale = 2\nrounding = \"HALF_EVEN\""), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Scale == nil || *f.Scale != 2 || f.Rounding != RoundHalfEven {
+		t.Errorf("got scale %v rounding %q", f.Scale, f.Rounding)
+	}
+}
+
+func TestFieldTypeAliases(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.toml")
+	body := `
+[schema]
+
+  [schema.ratio]
+    bigquery_type = "FLOAT64"
+    source_field = "ratio"
+    source_field_type = "double"
+
+  [schema.total]
+    bigquery_type = "BIGDECIMAL"
+    source_field = "total"
+    source_field_type = "number"
+`
+	if err := os.WriteFile(filename, []byte(body), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := LoadConfigFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.Schema["ratio"].Type; got != bigquery.FloatFieldType {
+		t.Errorf("got %s expected FLOAT", got)
+	}
+	if got := cf.Schema["total"].Type; got != bigquery.BigNumericFieldType {
+		t.Errorf("got %s expected BIGNUMERIC", got)
+	}
+}
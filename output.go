@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// outputWriter writes BigQuery-ready records to a local file or stdout
+type outputWriter struct {
+	name string
+	f    *os.File
+	w    recordWriter
+}
+
+// outputPath returns the local path for an -output value of "-" (stdout),
+// a file:// URL or a plain path
+func outputPath(output string) (string, error) {
+	if output == "-" {
+		return output, nil
+	}
+	if strings.Contains(output, "://") {
+		u, err := url.Parse(output)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "file" {
+			return "", fmt.Errorf("unsupported -output %q (expected file:///path or -)", output)
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			return "", fmt.Errorf("unsupported -output host %q", u.Host)
+		}
+		output = u.Path
+	}
+	if output == "" {
+		return "", fmt.Errorf("missing -output path")
+	}
+	return output, nil
+}
+
+// newOutputWriter opens output for writing. Paths ending in .parquet are written
+// as Parquet, otherwise as newline delimited JSON which is gzip compressed when the
+// path ends in .gz.
+func newOutputWriter(output string, s TableSchema) (*outputWriter, error) {
+	name, err := outputPath(output)
+	if err != nil {
+		return nil, err
+	}
+	format := FormatJSON
+	if strings.HasSuffix(name, ".parquet") {
+		format = FormatParquet
+	}
+	o := &outputWriter{name: name, f: os.Stdout}
+	if name != "-" {
+		o.f, err = os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	o.w, err = newRecordWriter(o.f, format, s, strings.HasSuffix(name, ".gz"))
+	if err != nil {
+		_ = o.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *outputWriter) String() string {
+	if o.name == "-" {
+		return "stdout"
+	}
+	return o.name
+}
+
+func (o *outputWriter) Write(r Record) error {
+	return o.w.Write(r)
+}
+
+func (o *outputWriter) Close() error {
+	if o.w != nil {
+		if err := o.w.Close(); err != nil {
+			return err
+		}
+	}
+	if o.f == os.Stdout {
+		return nil
+	}
+	return o.f.Close()
+}
+
+// exportOne streams the dataset from Socrata through the configured transform and writes
+// the result to output without making any BigQuery or GCS calls. Status messages go to
+// stderr so output can be stdout.
+func exportOne(configFile string, quiet bool, token, output string) {
+	cf, err := LoadConfigFile(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	apiBase := cf.APIBase()
+	datasetID := cf.DatasetID()
+
+	md, err := FetchMetadata(ctx, apiBase, datasetID, token)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Exporting Socrata: %s (%s) (last modified %v)\n", md.ID, md.Name, md.RowsUpdatedAtTime().Format(time.RFC3339))
+
+	where := cf.BigQuery.WhereFilter
+	socrataCount, err := CountV3(ctx, apiBase, datasetID, where, token)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Socrata Records: %d\n", socrataCount)
+
+	o, err := newOutputWriter(output, cf.Schema)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "> writing to %s\n", o)
+
+	var rows int64
+	start := time.Now()
+	handle := func(row Record) error {
+		mm, err := TransformOne(row, cf.Schema)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", rows+1, err)
+		}
+		if mm == nil {
+			return nil
+		}
+		rows++
+		if !quiet && rows%100000 == 0 {
+			elapsed := time.Since(start)
+			remainingRows, estimatedRemaining := estimate(rows, socrataCount, elapsed)
+			if remainingRows > 0 && estimatedRemaining > 0 {
+				log.Printf("processed %d rows (%s) - estimated remaining %d : %s", rows, elapsed.Truncate(time.Second), remainingRows, estimatedRemaining.Truncate(time.Second))
+			} else {
+				log.Printf("processed %d rows (%s)", rows, elapsed.Truncate(time.Second))
+			}
+		}
+		return o.Write(mm)
+	}
+	err = StreamPagedV3(ctx, apiBase, datasetID, "SELECT :*, *", where, token, cf.Order(), cf.PageSize, Cursor{}, handle)
+	if cerr := o.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d records to %s\n", rows, o)
+	fmt.Fprintf(os.Stderr, "Export Complete\n")
+}
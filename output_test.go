@@ -0,0 +1,85 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		output string
+		expect string
+		err    bool
+	}{
+		{"-", "-", false},
+		{"out.jsonl", "out.jsonl", false},
+		{"file:///tmp/out.jsonl.gz", "/tmp/out.jsonl.gz", false},
+		{"file://localhost/tmp/out.jsonl", "/tmp/out.jsonl", false},
+		{"file://host/tmp/out.jsonl", "", true},
+		{"gs://bucket/out.jsonl", "", true},
+		{"file://", "", true},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := outputPath(tc.output)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Fatalf("got %q expected %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestOutputWriter(t *testing.T) {
+	for _, name := range []string{"out.jsonl", "out.jsonl.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			o, err := newOutputWriter("file://"+path, TableSchema{"a": {Type: bigquery.StringFieldType}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Write(Record{"a": "<b>"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Write(Record{"a": 1}); err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var r io.Reader = f
+			if filepath.Ext(name) == ".gz" {
+				if r, err = gzip.NewReader(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			body, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expect := "{\"a\":\"<b>\"}\n{\"a\":1}\n"; string(body) != expect {
+				t.Fatalf("got %q expected %q", body, expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/schema"
+)
+
+// parquetWriter writes records as a Parquet file with one flat column per schema
+// field using the Apache Arrow Parquet writer. Values are buffered and written
+// as a GZIP compressed row group every parquetRowGroupRows rows.
+type parquetWriter struct {
+	w       *bufio.Writer
+	fw      *file.Writer
+	columns []*parquetColumn
+	rows    int64 // rows in the current row group
+}
+
+const parquetRowGroupRows = 100000
+
+const (
+	// BigQuery NUMERIC is DECIMAL(38, 9)
+	numericPrecision = 38
+	numericScale     = 9
+	numericBytes     = 16
+
+	// BigQuery BIGNUMERIC is DECIMAL(76, 38)
+	bigNumericPrecision = 76
+	bigNumericScale     = 38
+	bigNumericBytes     = 32
+)
+
+// parquetColumn buffers the values of a column for the current row group. Only the
+// slice for the column's physical type is used.
+type parquetColumn struct {
+	name  string
+	field SchemaField
+
+	defLevels []int16 // 0 for null values; only used for optional columns
+	bools     []bool
+	int32s    []int32
+	int64s    []int64
+	doubles   []float64
+	bytes     []parquet.ByteArray
+	fixed     []parquet.FixedLenByteArray
+}
+
+func newParquetWriter(w io.Writer, s TableSchema) (*parquetWriter, error) {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	p := &parquetWriter{w: bufio.NewWriterSize(w, 1*1024*1024)}
+	var fields schema.FieldList
+	for _, name := range names {
+		c := &parquetColumn{name: name, field: s[name]}
+		n, err := c.node()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, n)
+		p.columns = append(p.columns, c)
+	}
+	root, err := schema.NewGroupNode("schema", parquet.Repetitions.Required, fields, -1)
+	if err != nil {
+		return nil, err
+	}
+	props := parquet.NewWriterProperties(
+		parquet.WithCompression(compress.Codecs.Gzip),
+		parquet.WithCreatedBy("socrata_to_bigquery"),
+	)
+	// p.w is not an io.Closer so closing the file writer doesn't close w
+	p.fw = file.NewParquetWriter(p.w, root, file.WithWriterProps(props))
+	return p, nil
+}
+
+// node returns the Parquet schema node for the column's BigQuery type
+func (c *parquetColumn) node() (schema.Node, error) {
+	repetition := parquet.Repetitions.Optional
+	if c.field.Required {
+		repetition = parquet.Repetitions.Required
+	}
+	var logical schema.LogicalType = schema.NoLogicalType{}
+	var physical parquet.Type
+	typeLen := -1
+	switch c.field.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		logical, physical = schema.StringLogicalType{}, parquet.Types.ByteArray
+	case bigquery.NumericFieldType:
+		logical, physical, typeLen = schema.NewDecimalLogicalType(numericPrecision, numericScale), parquet.Types.FixedLenByteArray, numericBytes
+	case bigquery.BigNumericFieldType:
+		logical, physical, typeLen = schema.NewDecimalLogicalType(bigNumericPrecision, bigNumericScale), parquet.Types.FixedLenByteArray, bigNumericBytes
+	case bigquery.FloatFieldType:
+		physical = parquet.Types.Double
+	case bigquery.IntegerFieldType:
+		physical = parquet.Types.Int64
+	case bigquery.BooleanFieldType:
+		physical = parquet.Types.Boolean
+	case bigquery.DateFieldType:
+		logical, physical = schema.DateLogicalType{}, parquet.Types.Int32
+	case bigquery.TimeFieldType:
+		logical, physical = schema.NewTimeLogicalType(true, schema.TimeUnitMicros), parquet.Types.Int64
+	case bigquery.TimestampFieldType:
+		logical, physical = schema.NewTimestampLogicalType(true, schema.TimeUnitMicros), parquet.Types.Int64
+	case bigquery.DateTimeFieldType:
+		// a timestamp not adjusted to UTC is loaded as DATETIME
+		logical, physical = schema.NewTimestampLogicalType(false, schema.TimeUnitMicros), parquet.Types.Int64
+	default:
+		return nil, fmt.Errorf("unsupported BigQuery type %q for parquet field %q", c.field.Type, c.name)
+	}
+	return schema.NewPrimitiveNodeLogical(c.name, repetition, logical, physical, typeLen, -1)
+}
+
+// Write appends a record converted by TransformOne
+func (p *parquetWriter) Write(r Record) error {
+	for _, c := range p.columns {
+		if err := c.append(r[c.name]); err != nil {
+			return fmt.Errorf("parquet field %q: %w", c.name, err)
+		}
+	}
+	p.rows++
+	if p.rows >= parquetRowGroupRows {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+// Close writes any buffered rows and the file footer. It does not close the underlying writer.
+func (p *parquetWriter) Close() error {
+	if p.rows > 0 {
+		if err := p.flushRowGroup(); err != nil {
+			return err
+		}
+	}
+	if err := p.fw.Close(); err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+func (p *parquetWriter) flushRowGroup() error {
+	rg := p.fw.AppendRowGroup()
+	for _, c := range p.columns {
+		cw, err := rg.NextColumn()
+		if err != nil {
+			return err
+		}
+		if err := c.writeTo(cw); err != nil {
+			return fmt.Errorf("parquet field %q: %w", c.name, err)
+		}
+		if err := cw.Close(); err != nil {
+			return err
+		}
+		c.reset()
+	}
+	p.rows = 0
+	return rg.Close()
+}
+
+// writeTo writes the buffered values to the column chunk
+func (c *parquetColumn) writeTo(cw file.ColumnChunkWriter) error {
+	var err error
+	switch w := cw.(type) {
+	case *file.BooleanColumnChunkWriter:
+		_, err = w.WriteBatch(c.bools, c.defLevels, nil)
+	case *file.Int32ColumnChunkWriter:
+		_, err = w.WriteBatch(c.int32s, c.defLevels, nil)
+	case *file.Int64ColumnChunkWriter:
+		_, err = w.WriteBatch(c.int64s, c.defLevels, nil)
+	case *file.Float64ColumnChunkWriter:
+		_, err = w.WriteBatch(c.doubles, c.defLevels, nil)
+	case *file.ByteArrayColumnChunkWriter:
+		_, err = w.WriteBatch(c.bytes, c.defLevels, nil)
+	case *file.FixedLenByteArrayColumnChunkWriter:
+		_, err = w.WriteBatch(c.fixed, c.defLevels, nil)
+	default:
+		err = fmt.Errorf("unexpected column writer %T", cw)
+	}
+	return err
+}
+
+func (c *parquetColumn) reset() {
+	c.defLevels = c.defLevels[:0]
+	c.bools = c.bools[:0]
+	c.int32s = c.int32s[:0]
+	c.int64s = c.int64s[:0]
+	c.doubles = c.doubles[:0]
+	c.bytes = c.bytes[:0]
+	c.fixed = c.fixed[:0]
+}
+
+func (c *parquetColumn) append(v interface{}) error {
+	if v == nil {
+		if c.field.Required {
+			return fmt.Errorf("missing required value")
+		}
+		c.defLevels = append(c.defLevels, 0)
+		return nil
+	}
+	if err := c.appendValue(v); err != nil {
+		return err
+	}
+	if !c.field.Required {
+		c.defLevels = append(c.defLevels, 1)
+	}
+	return nil
+}
+
+func (c *parquetColumn) appendValue(v interface{}) error {
+	switch c.field.Type {
+	case bigquery.StringFieldType:
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		c.bytes = append(c.bytes, parquet.ByteArray(s))
+	case bigquery.GeographyFieldType:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("unexpected GEOGRAPHY value %T", v)
+		}
+		if strings.HasPrefix(strings.TrimSpace(s), "{") {
+			var err error
+			if s, err = GeoJSONToWKT(s); err != nil {
+				return err
+			}
+		}
+		// otherwise already Well Known Text
+		c.bytes = append(c.bytes, parquet.ByteArray(s))
+	case bigquery.NumericFieldType:
+		b, err := decimalBytes(v, numericScale, numericBytes)
+		if err != nil {
+			return err
+		}
+		c.fixed = append(c.fixed, b)
+	case bigquery.BigNumericFieldType:
+		b, err := decimalBytes(v, bigNumericScale, bigNumericBytes)
+		if err != nil {
+			return err
+		}
+		c.fixed = append(c.fixed, b)
+	case bigquery.FloatFieldType:
+		f, err := toFloat64(v)
+		if err != nil {
+			return err
+		}
+		c.doubles = append(c.doubles, f)
+	case bigquery.IntegerFieldType:
+		var n int64
+		switch x := v.(type) {
+		case int64:
+			n = x
+		case int:
+			n = int64(x)
+		case float64:
+			if x != math.Trunc(x) {
+				return fmt.Errorf("invalid INTEGER %v", x)
+			}
+			n = int64(x)
+		case string:
+			var err error
+			if n, err = strconv.ParseInt(x, 10, 64); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected INTEGER value %T", v)
+		}
+		c.int64s = append(c.int64s, n)
+	case bigquery.BooleanFieldType:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("unexpected BOOLEAN value %T", v)
+		}
+		c.bools = append(c.bools, b)
+	case bigquery.DateFieldType:
+		t, err := parseValueTime(v, "2006-01-02")
+		if err != nil {
+			return err
+		}
+		c.int32s = append(c.int32s, int32(t.Unix()/86400))
+	case bigquery.TimeFieldType:
+		t, err := parseValueTime(v, "15:04:05.999999")
+		if err != nil {
+			return err
+		}
+		micros := int64(t.Hour())*3600e6 + int64(t.Minute())*60e6 + int64(t.Second())*1e6 + int64(t.Nanosecond()/1000)
+		c.int64s = append(c.int64s, micros)
+	case bigquery.TimestampFieldType:
+		t, err := parseValueTime(v, time.RFC3339Nano)
+		if err != nil {
+			return err
+		}
+		c.int64s = append(c.int64s, t.UnixMicro())
+	case bigquery.DateTimeFieldType:
+		t, err := parseValueTime(v, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999")
+		if err != nil {
+			return err
+		}
+		c.int64s = append(c.int64s, t.UnixMicro())
+	}
+	return nil
+}
+
+// parseValueTime parses a string value using the first matching layout
+func parseValueTime(v interface{}, layouts ...string) (time.Time, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case string:
+		var err error
+		for _, layout := range layouts {
+			var t time.Time
+			if t, err = time.Parse(layout, x); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("unexpected time value %T", v)
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case int64:
+		return float64(x), nil
+	case string:
+		return strconv.ParseFloat(x, 64)
+	case json.Number:
+		return x.Float64()
+	}
+	return 0, fmt.Errorf("unexpected numeric value %T", v)
+}
+
+// decimalBytes returns v scaled by 10^scale as a big-endian two's complement integer of size bytes.
+// Digits beyond scale are truncated.
+func decimalBytes(v interface{}, scale, size int) ([]byte, error) {
+	var s string
+	switch x := v.(type) {
+	case string:
+		s = x
+	case json.Number:
+		s = string(x)
+	case float64:
+		s = strconv.FormatFloat(x, 'f', -1, 64)
+	case int64:
+		s = strconv.FormatInt(x, 10)
+	default:
+		return nil, fmt.Errorf("unexpected NUMERIC value %T", v)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid NUMERIC %q", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	n := new(big.Int).Quo(r.Num(), r.Denom())
+
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(size*8-1))
+	if n.CmpAbs(limit) >= 0 {
+		return nil, fmt.Errorf("NUMERIC %q out of range", s)
+	}
+	if n.Sign() < 0 {
+		n.Add(n, new(big.Int).Lsh(big.NewInt(1), uint(size*8)))
+	}
+	return n.FillBytes(make([]byte, size)), nil
+}
+
+// GeoJSONToWKT converts a GeoJSON geometry to Well Known Text which BigQuery loads into GEOGRAPHY columns
+func GeoJSONToWKT(s string) (string, error) {
+	var g struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&g); err != nil {
+		return "", fmt.Errorf("GeoJSONToWKT: %w", err)
+	}
+	var coords interface{}
+	dec = json.NewDecoder(bytes.NewReader(g.Coordinates))
+	dec.UseNumber()
+	if err := dec.Decode(&coords); err != nil {
+		return "", fmt.Errorf("GeoJSONToWKT: %s coordinates %w", g.Type, err)
+	}
+
+	var depth int
+	switch g.Type {
+	case "Point":
+		depth = 0
+	case "MultiPoint", "LineString":
+		depth = 1
+	case "MultiLineString", "Polygon":
+		depth = 2
+	case "MultiPolygon":
+		depth = 3
+	default:
+		return "", fmt.Errorf("GeoJSONToWKT: unsupported type %q", g.Type)
+	}
+	body, err := wktCoordinates(coords, depth)
+	if err != nil {
+		return "", fmt.Errorf("GeoJSONToWKT: %s %w", g.Type, err)
+	}
+	return strings.ToUpper(g.Type) + body, nil
+}
+
+// wktCoordinates formats nested GeoJSON coordinate arrays; depth 0 is a single position
+func wktCoordinates(v interface{}, depth int) (string, error) {
+	a, ok := v.([]interface{})
+	if !ok {
+		return "", fmt.Errorf("invalid coordinates %v", v)
+	}
+	var parts []string
+	for _, p := range a {
+		if depth == 0 {
+			n, ok := p.(json.Number)
+			if !ok {
+				return "", fmt.Errorf("invalid position %v", a)
+			}
+			parts = append(parts, n.String())
+			continue
+		}
+		s, err := wktCoordinates(p, depth-1)
+		if err != nil {
+			return "", err
+		}
+		if depth == 1 {
+			s = s[1 : len(s)-1] // positions are not wrapped in parentheses
+		}
+		parts = append(parts, s)
+	}
+	if depth == 0 {
+		if len(parts) < 2 {
+			return "", fmt.Errorf("invalid position %v", a)
+		}
+		return "(" + strings.Join(parts, " ") + ")", nil
+	}
+	return "(" + strings.Join(parts, ", ") + ")", nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/metadata"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// readParquet decodes a file with the Apache Arrow Parquet reader into column values
+// (nil for nulls). Decimals are returned as their unscaled integer and dates, times
+// and timestamps as their integer Parquet values.
+func readParquet(t *testing.T, b []byte) (*metadata.FileMetaData, map[string][]interface{}) {
+	t.Helper()
+	pf, err := file.NewParquetReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := fr.ReadTable(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Release()
+
+	columns := make(map[string][]interface{})
+	for i := 0; i < int(table.NumCols()); i++ {
+		col := table.Column(i)
+		for _, chunk := range col.Data().Chunks() {
+			for j := 0; j < chunk.Len(); j++ {
+				if chunk.IsNull(j) {
+					columns[col.Name()] = append(columns[col.Name()], nil)
+					continue
+				}
+				var v interface{}
+				switch a := chunk.(type) {
+				case *array.String:
+					v = a.Value(j)
+				case *array.Boolean:
+					v = a.Value(j)
+				case *array.Int64:
+					v = a.Value(j)
+				case *array.Float64:
+					v = a.Value(j)
+				case *array.Date32:
+					v = int64(a.Value(j))
+				case *array.Time64:
+					v = int64(a.Value(j))
+				case *array.Timestamp:
+					v = int64(a.Value(j))
+				case *array.Decimal128:
+					v = a.Value(j).BigInt().String()
+				case *array.Decimal256:
+					v = a.Value(j).BigInt().String()
+				default:
+					t.Fatalf("unexpected column %s %T", col.Name(), chunk)
+				}
+				columns[col.Name()] = append(columns[col.Name()], v)
+			}
+		}
+	}
+	return pf.MetaData(), columns
+}
+
+func TestParquetWriter(t *testing.T) {
+	s := TableSchema{
+		"_id":     {Type: bigquery.StringFieldType, Required: true},
+		"amount":  {Type: bigquery.NumericFieldType},
+		"count":   {Type: bigquery.IntegerFieldType},
+		"created": {Type: bigquery.TimestampFieldType, Required: true},
+		"day":     {Type: bigquery.DateFieldType},
+		"flag":    {Type: bigquery.BooleanFieldType},
+		"local":   {Type: bigquery.DateTimeFieldType},
+		"point":   {Type: bigquery.GeographyFieldType},
+		"ratio":   {Type: bigquery.FloatFieldType},
+		"time":    {Type: bigquery.TimeFieldType},
+	}
+	records := []Record{
+		{"_id": "row-1", "amount": "12.5", "count": "3", "created": "2024-01-02T03:04:05.123Z", "day": "2024-01-02", "flag": true, "local": "2024-01-02T03:04:05.000", "point": `{"type":"Point","coordinates":[-73.96481,40.633247]}`, "ratio": 0.25, "time": "13:45:00"},
+		{"_id": "row-2", "amount": -1.000000001, "created": "1970-01-01T00:00:00Z", "flag": false},
+		{"_id": "row-3", "created": "1970-01-01T00:00:01Z", "flag": true},
+	}
+
+	var b bytes.Buffer
+	w, err := newParquetWriter(&b, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	md, got := readParquet(t, b.Bytes())
+	if md.NumRows != 3 {
+		t.Errorf("got num_rows %d expected 3", md.NumRows)
+	}
+	logicalTypes := map[string]string{
+		"_id":     "String",
+		"amount":  "Decimal(precision=38, scale=9)",
+		"created": `Timestamp(isAdjustedToUTC=true, timeUnit=microseconds, is_from_converted_type=false, force_set_converted_type=false)`,
+		"day":     "Date",
+		"local":   `Timestamp(isAdjustedToUTC=false, timeUnit=microseconds, is_from_converted_type=false, force_set_converted_type=false)`,
+		"time":    "Time(isAdjustedToUTC=true, timeUnit=microseconds)",
+	}
+	for i := 0; i < md.Schema.NumColumns(); i++ {
+		c := md.Schema.Column(i)
+		if expect, ok := logicalTypes[c.Name()]; ok && c.LogicalType().String() != expect {
+			t.Errorf("column %s got logical type %s expected %s", c.Name(), c.LogicalType(), expect)
+		}
+		if required := c.MaxDefinitionLevel() == 0; required != s[c.Name()].Required {
+			t.Errorf("column %s got required %v", c.Name(), required)
+		}
+	}
+	expect := map[string][]interface{}{
+		"_id":     {"row-1", "row-2", "row-3"},
+		"amount":  {"12500000000", "-1000000001", nil},
+		"count":   {int64(3), nil, nil},
+		"created": {int64(1704164645123000), int64(0), int64(1000000)},
+		"day":     {int64(19724), nil, nil},
+		"flag":    {true, false, true},
+		"local":   {int64(1704164645000000), nil, nil},
+		"point":   {"POINT(-73.96481 40.633247)", nil, nil},
+		"ratio":   {0.25, nil, nil},
+		"time":    {int64(49500000000), nil, nil},
+	}
+	for name, values := range expect {
+		if !reflect.DeepEqual(got[name], values) {
+			t.Errorf("column %s got %#v expected %#v", name, got[name], values)
+		}
+	}
+}
+
+func TestParquetWriter_RowGroups(t *testing.T) {
+	var b bytes.Buffer
+	w, err := newParquetWriter(&b, TableSchema{"n": {Type: bigquery.IntegerFieldType}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= parquetRowGroupRows; i++ {
+		var v interface{}
+		if i%2 == 0 {
+			v = int64(i)
+		}
+		if err := w.Write(Record{"n": v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	md, got := readParquet(t, b.Bytes())
+	if len(md.RowGroups) != 2 || md.NumRows != parquetRowGroupRows+1 {
+		t.Fatalf("got %d row groups %d rows", len(md.RowGroups), md.NumRows)
+	}
+	if last := got["n"][parquetRowGroupRows]; last != int64(parquetRowGroupRows) || got["n"][1] != nil {
+		t.Fatalf("got %v, %v", got["n"][1], last)
+	}
+}
+
+func TestParquetWriter_Required(t *testing.T) {
+	w, err := newParquetWriter(io.Discard, TableSchema{"_id": {Type: bigquery.StringFieldType, Required: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(Record{"_id": nil}); err == nil {
+		t.Fatal("expected error for missing required value")
+	}
+}
+
+func TestDecimalBytes(t *testing.T) {
+	tests := []struct {
+		v      interface{}
+		expect string
+		err    bool
+	}{
+		{"0", "00000000000000000000000000000000", false},
+		{"1", "0000000000000000000000003b9aca00", false},
+		{"-1", "ffffffffffffffffffffffffc4653600", false},
+		{"1.0000000019", "0000000000000000000000003b9aca01", false},
+		{1.5, "00000000000000000000000059682f00", false},
+		{"99999999999999999999999999999.999999999", "4b3b4ca85a86c47a098a223fffffffff", false},
+		{"1e40", "", true},
+		{"abc", "", true},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := decimalBytes(tc.v, numericScale, numericBytes)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error got %x", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%x", got) != tc.expect {
+				t.Fatalf("got %x expected %s", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestGeoJSONToWKT(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect string
+	}{
+		{`{"type":"Point","coordinates":[-73.96481,40.633247]}`, "POINT(-73.96481 40.633247)"},
+		{`{"type":"LineString","coordinates":[[1,2],[3,4]]}`, "LINESTRING(1 2, 3 4)"},
+		{`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`, "POLYGON((0 0, 1 0, 1 1, 0 0))"},
+		{`{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]]]}`, "MULTIPOLYGON(((0 0, 1 0, 1 1, 0 0)))"},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := GeoJSONToWKT(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Fatalf("got %q expected %q", got, tc.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+var (
+	integerPattern = regexp.MustCompile(`^[-+]?\d+$`)
+	decimalPattern = regexp.MustCompile(`^[-+]?(\d*)\.(\d+)$`)
+)
+
+// columnProfile summarizes the sampled values of a Socrata column
+type columnProfile struct {
+	Rows         int
+	Nulls        int // null or blank
+	Integers     int // fit in an INTEGER
+	Decimals     int
+	Booleans     int // Y/N, Yes/No, True/False
+	LeadingZeros int // integers or decimals with a leading 0, i.e. ZIP codes
+	MaxScale     int // most digits after the decimal point
+	MaxPrecision int // most digits before the decimal point
+	Values       []string
+}
+
+// profileColumn classifies the values of field in the sample rows
+func profileColumn(rows []map[string]interface{}, field string) columnProfile {
+	p := columnProfile{Rows: len(rows)}
+	for _, row := range rows {
+		var s string
+		switch v := row[field].(type) {
+		case nil:
+		case string:
+			s = strings.TrimSpace(v)
+		default:
+			// numbers, checkboxes and objects are typed by Socrata
+			var err error
+			if s, err = numberString(v); err != nil {
+				s = fmt.Sprint(v)
+			}
+		}
+		if s == "" {
+			p.Nulls++
+			continue
+		}
+		p.Values = append(p.Values, s)
+		n := strings.ReplaceAll(s, ",", "")
+		digits := strings.TrimLeft(n, "-+")
+		isInteger := integerPattern.MatchString(n)
+		decimal := decimalPattern.FindStringSubmatch(n)
+		if (isInteger || decimal != nil) && len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+			p.LeadingZeros++
+		}
+		switch {
+		case isInteger:
+			if _, err := strconv.ParseInt(n, 10, 64); err == nil {
+				p.Integers++
+			} else {
+				p.Decimals++
+			}
+			p.MaxPrecision = max(p.MaxPrecision, len(digits))
+		case decimal != nil:
+			p.Decimals++
+			p.MaxPrecision = max(p.MaxPrecision, len(decimal[1]))
+			p.MaxScale = max(p.MaxScale, len(decimal[2]))
+		default:
+			if _, err := ToBoolean(s); err == nil {
+				p.Booleans++
+			}
+		}
+	}
+	return p
+}
+
+// Infer returns f with the narrowest type that holds every sampled value and the
+// evidence in Profile. Only text and number columns change type. Fields are never
+// made required from a sample; when no sampled value is null the profile suggests it.
+// An inferred INTEGER fails the sync (on_error = ERROR) on a later non-integer value
+// rather than skipping the value or the row.
+func (p columnProfile) Infer(f SchemaField) SchemaField {
+	nonNull := p.Rows - p.Nulls
+	evidence := []string{fmt.Sprintf("%d null", p.Nulls)}
+	if nonNull == 0 {
+		f.Profile = fmt.Sprintf("%d rows sampled: all null", p.Rows)
+		return f
+	}
+	if p.Integers > 0 {
+		evidence = append(evidence, fmt.Sprintf("%d integer", p.Integers))
+	}
+	if p.Decimals > 0 {
+		evidence = append(evidence, fmt.Sprintf("%d decimal (%d digits, scale %d)", p.Decimals, p.MaxPrecision+p.MaxScale, p.MaxScale))
+	}
+	if p.LeadingZeros > 0 {
+		evidence = append(evidence, fmt.Sprintf("%d with leading zeros", p.LeadingZeros))
+	}
+	if p.Booleans > 0 {
+		evidence = append(evidence, fmt.Sprintf("%d boolean", p.Booleans))
+	}
+
+	numeric := p.Integers + p.Decimals
+	switch f.SourceFieldType {
+	case "number":
+		if p.Integers == nonNull {
+			f.Type, f.OnError = bigquery.IntegerFieldType, RaiseError
+		}
+	case "text":
+		switch {
+		case numeric == nonNull && p.LeadingZeros > 0:
+			// codes like ZIPs would lose their leading zeros
+		case p.Integers == nonNull:
+			f.Type, f.OnError = bigquery.IntegerFieldType, RaiseError
+		case numeric == nonNull && p.MaxPrecision <= 29 && p.MaxScale <= 9:
+			f.Type = bigquery.NumericFieldType
+		case numeric == nonNull:
+			f.Type = bigquery.FloatFieldType
+		case p.Booleans == nonNull:
+			f.Type = bigquery.BooleanFieldType
+		default:
+			if t, formats, ok := detectTimeFormats(p.Values); ok {
+				f.Type, f.TimeFormat, f.OnError = t, formats, SkipValue
+				evidence = append(evidence, fmt.Sprintf("%d %s", nonNull, t))
+			}
+		}
+	}
+	if other := nonNull - numeric - p.Booleans; other > 0 && f.Type == bigquery.StringFieldType {
+		evidence = append(evidence, fmt.Sprintf("%d other", other))
+	}
+	f.Profile = fmt.Sprintf("%d rows sampled: %s", p.Rows, strings.Join(evidence, ", "))
+	if p.Nulls == 0 && !f.Required {
+		f.Profile += "; suggest required = true"
+	}
+	return f
+}
+
+// profileSchema infers the type and time_format of each column mapped
+// from a Socrata column using the sample rows
+func profileSchema(s TableSchema, sample []map[string]interface{}) {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := s[name]
+		if strings.HasPrefix(f.SourceField, ":") {
+			continue
+		}
+		inferred := profileColumn(sample, f.SourceField).Infer(f)
+		if inferred.Type != f.Type {
+			fmt.Printf("> inferred %s for %s (%s)\n", inferred.Type, name, inferred.Profile)
+		}
+		s[name] = inferred
+	}
+}
+
+// samplePageSize is the most records requested at once while sampling
+const samplePageSize = 1000
+
+// errSampleFull stops the sample stream once enough records have been read
+var errSampleFull = errors.New("sample full")
+
+// FetchSampleRecords streams up to limit records in pages for profiling and example values
+func FetchSampleRecords(ctx context.Context, apiBase *url.URL, datasetID, token string, limit int) ([]map[string]interface{}, error) {
+	fmt.Printf("Fetching %d sample records.\n", limit)
+	var sample []map[string]interface{}
+	err := StreamPagedV3(ctx, apiBase, datasetID, "SELECT :*, *", "", token, PageByID, int64(min(limit, samplePageSize)), Cursor{}, func(row Record) error {
+		sample = append(sample, map[string]interface{}(row))
+		if len(sample) >= limit {
+			return errSampleFull
+		}
+		return nil
+	})
+	if errors.Is(err, errSampleFull) {
+		err = nil
+	}
+	return sample, err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestProfileInfer(t *testing.T) {
+	type testCase struct {
+		sourceType string
+		values     []interface{}
+		expected   SchemaField
+	}
+	text := func(f SchemaField) SchemaField {
+		f.SourceField, f.SourceFieldType = "v", "text"
+		return f
+	}
+	tests := []testCase{
+		{"text", []interface{}{"1", "-20", "1,234"}, text(SchemaField{Type: bigquery.IntegerFieldType, OnError: RaiseError, Profile: "3 rows sampled: 0 null, 3 integer; suggest required = true"})},
+		{"text", []interface{}{"1", nil, "2.50"}, text(SchemaField{Type: bigquery.NumericFieldType, Profile: "3 rows sampled: 1 null, 1 integer, 1 decimal (3 digits, scale 2)"})},
+		{"text", []interface{}{"1.0123456789", " "}, text(SchemaField{Type: bigquery.FloatFieldType, Profile: "2 rows sampled: 1 null, 1 decimal (11 digits, scale 10)"})},
+		{"text", []interface{}{"10001", "07302"}, text(SchemaField{Type: bigquery.StringFieldType, Profile: "2 rows sampled: 0 null, 2 integer, 1 with leading zeros; suggest required = true"})},
+		{"text", []interface{}{"99999999999999999999"}, text(SchemaField{Type: bigquery.NumericFieldType, Profile: "1 rows sampled: 0 null, 1 decimal (20 digits, scale 0); suggest required = true"})},
+		{"text", []interface{}{"Y", "n", "Yes"}, text(SchemaField{Type: bigquery.BooleanFieldType, Profile: "3 rows sampled: 0 null, 3 boolean; suggest required = true"})},
+		{"text", []interface{}{"03/06/2017", "2017-03-07", ""}, text(SchemaField{Type: bigquery.DateFieldType, TimeFormat: TimeFormats{"01/02/2006", "2006-01-02"}, OnError: SkipValue, Profile: "3 rows sampled: 1 null, 2 DATE"})},
+		{"text", []interface{}{"2017-03-06T10:15:00Z"}, text(SchemaField{Type: bigquery.TimestampFieldType, TimeFormat: TimeFormats{"2006-01-02T15:04:05Z07:00"}, OnError: SkipValue, Profile: "1 rows sampled: 0 null, 1 TIMESTAMP; suggest required = true"})},
+		{"text", []interface{}{"Brooklyn", "12", "Y"}, text(SchemaField{Type: bigquery.StringFieldType, Profile: "3 rows sampled: 0 null, 1 integer, 1 boolean, 1 other; suggest required = true"})},
+		{"text", []interface{}{nil, ""}, text(SchemaField{Type: bigquery.StringFieldType, Profile: "2 rows sampled: all null"})},
+		{"number", []interface{}{"12", 3.0, nil}, SchemaField{SourceField: "v", SourceFieldType: "number", Type: bigquery.IntegerFieldType, OnError: RaiseError, Profile: "3 rows sampled: 1 null, 2 integer"}},
+		{"number", []interface{}{"12", "3.5"}, SchemaField{SourceField: "v", SourceFieldType: "number", Type: bigquery.NumericFieldType, Profile: "2 rows sampled: 0 null, 1 integer, 1 decimal (3 digits, scale 1); suggest required = true"}},
+		{"checkbox", []interface{}{true, false}, SchemaField{SourceField: "v", SourceFieldType: "checkbox", Type: bigquery.BooleanFieldType, Profile: "2 rows sampled: 0 null, 2 boolean; suggest required = true"}},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var rows []map[string]interface{}
+			for _, v := range tc.values {
+				rows = append(rows, map[string]interface{}{"v": v})
+			}
+			c := SocrataColumn{FieldName: "v", DataTypeName: tc.sourceType}
+			f, err := NewSchemaField(c, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := profileColumn(rows, "v").Infer(f)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got      %#v\nexpected %#v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestProfileSchema(t *testing.T) {
+	s := TableSchema{
+		"_id":    {SourceField: ":id", Type: bigquery.StringFieldType},
+		"issued": {SourceField: "issued", SourceFieldType: "text", Type: bigquery.StringFieldType},
+		"link":   {SourceField: "link", SourceFieldType: "url", Type: bigquery.StringFieldType},
+	}
+	sample := []map[string]interface{}{
+		{":id": "row-1", "issued": "03/06/2017", "link": "2017-01-01"},
+		{":id": "row-2", "issued": "2017-03-07"},
+	}
+	profileSchema(s, sample)
+	expected := SchemaField{SourceField: "issued", SourceFieldType: "text", Type: bigquery.DateFieldType, TimeFormat: TimeFormats{"01/02/2006", "2006-01-02"}, OnError: SkipValue, Profile: "2 rows sampled: 0 null, 2 DATE; suggest required = true"}
+	if !reflect.DeepEqual(s["issued"], expected) {
+		t.Errorf("got %#v", s["issued"])
+	}
+	if s["link"].Type != bigquery.StringFieldType || s["link"].Required {
+		t.Errorf("got %s required %v expected nullable STRING", s["link"].Type, s["link"].Required)
+	}
+	if s["_id"].Profile != "" {
+		t.Errorf("system field _id should not be profiled")
+	}
+}
+
+func TestToBoolean(t *testing.T) {
+	type testCase struct {
+		in       string
+		expected interface{}
+		err      bool
+	}
+	tests := []testCase{
+		{"Y", true, false},
+		{" no ", false, false},
+		{"TRUE", true, false},
+		{"0", false, false},
+		{"", nil, false},
+		{"maybe", nil, true},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := ToBoolean(tc.in)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("got %#v expected %#v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFetchSampleRecords(t *testing.T) {
+	testRetryPolicy(t, 0)
+	var queries []string
+	apiBase := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body v3QueryBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		queries = append(queries, body.SQL)
+		_, _ = w.Write([]byte(`[{":id":"row-1"},{":id":"row-2"},{":id":"row-3"},{":id":"row-4"}]`))
+	})
+	sample, err := FetchSampleRecords(context.Background(), apiBase, "abcd-1234", "token", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sample) != 3 {
+		t.Errorf("got %d records expected 3", len(sample))
+	}
+	if len(queries) != 1 || queries[0] != "SELECT :*, * ORDER BY :id LIMIT 3" {
+		t.Errorf("got queries %q", queries)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// deletedAtField is the column set by DeleteMode SOFT when a record is removed from Socrata
+const deletedAtField = "_deleted_at"
+
+// loadSocrataIDs streams the :id of every Socrata record matching where into a
+// staging table with a single _id column. The caller is responsible for dropping the table.
+func loadSocrataIDs(ctx context.Context, dataset *bigquery.Dataset, cf ConfigFile, datasetID, where, token string) (*bigquery.Table, int64, error) {
+	f, err := os.CreateTemp("", datasetID+"-ids-*.json")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	bw := bufio.NewWriterSize(f, 1*1024*1024) // 1MB buffer
+	enc := json.NewEncoder(bw)
+
+	var count int64
+	handle := func(row Record) error {
+		id, ok := row[":id"].(string)
+		if !ok || id == "" {
+			return fmt.Errorf("row %d: missing :id", count+1)
+		}
+		count++
+		return enc.Encode(map[string]string{"_id": id})
+	}
+	err = StreamPagedV3(ctx, cf.APIBase(), datasetID, "SELECT :id", where, token, PageByID, cf.PageSize, Cursor{}, handle)
+	if err != nil {
+		return nil, count, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, count, err
+	}
+	fmt.Printf("Socrata IDs: %d\n", count)
+	if count == 0 {
+		return nil, count, nil
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, count, err
+	}
+
+	ids, err := createStagingTable(ctx, dataset, stagingTableName(cf, "ids"), bigquery.Schema{
+		{Name: "_id", Type: bigquery.StringFieldType, Required: true},
+	}, nil)
+	if err != nil {
+		return nil, count, err
+	}
+	src := bigquery.NewReaderSource(f)
+	src.SourceFormat = bigquery.JSON
+	loader := ids.LoaderFrom(src)
+	loader.WriteDisposition = bigquery.WriteTruncate
+	if err := runLoader(ctx, loader); err != nil {
+		dropStagingTable(ctx, ids)
+		return nil, count, err
+	}
+	return ids, count, nil
+}
+
+// deletedAtSchema is the nullable _deleted_at TIMESTAMP column added to the table by DeleteMode SOFT
+var deletedAtSchema = &bigquery.FieldSchema{
+	Name:        deletedAtField,
+	Description: "time the record was found to be deleted from Socrata",
+	Type:        bigquery.TimestampFieldType,
+}
+
+// ensureDeletedAtColumn adds the _deleted_at column to the table if needed
+func ensureDeletedAtColumn(ctx context.Context, bqTable *bigquery.Table, tmd *bigquery.TableMetadata) (*bigquery.TableMetadata, error) {
+	for _, f := range tmd.Schema {
+		if f.Name == deletedAtField {
+			return tmd, nil
+		}
+	}
+	return addBigQueryColumns(ctx, bqTable, tmd, bigquery.Schema{deletedAtSchema})
+}
+
+// deleteSQL builds the DML which removes (HARD) or marks (SOFT) rows in target whose _id is not in ids
+func deleteSQL(mode DeleteMode, target, ids string, s TableSchema) string {
+	where := []string{fmt.Sprintf("_id NOT IN (SELECT _id FROM %s)", ids)}
+	if f := s.PartitionFilter(); f != "" {
+		where = append(where, f)
+	}
+	if mode == DeleteSoft {
+		where = append(where, deletedAtField+" IS NULL")
+		return fmt.Sprintf("UPDATE %s SET %s = CURRENT_TIMESTAMP() WHERE %s", target, deletedAtField, strings.Join(where, " AND "))
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", target, strings.Join(where, " AND "))
+}
+
+// reconcileDeletes compares the :id values in Socrata with the _id values in
+// BigQuery and removes (or marks) the rows that no longer exist in Socrata.
+// With DeleteMode SOFT the table must already have a _deleted_at column.
+func reconcileDeletes(ctx context.Context, bqclient *bigquery.Client, dataset *bigquery.Dataset, cf ConfigFile, datasetID, token string, bqTable *bigquery.Table) error {
+	mode := cf.BigQuery.DeleteMode
+	fmt.Printf("Reconciling deleted records (DeleteMode %s)\n", mode)
+	switch mode {
+	case DeleteHard, DeleteSoft:
+	default:
+		return fmt.Errorf("unknown DeleteMode %q", mode)
+	}
+
+	ids, count, err := loadSocrataIDs(ctx, dataset, cf, datasetID, cf.BigQuery.WhereFilter, token)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		// an empty result is more likely a bad filter or API problem than a fully deleted dataset
+		return fmt.Errorf("no Socrata records found; refusing to remove all BigQuery records")
+	}
+	defer dropStagingTable(ctx, ids)
+
+	status, err := runQuery(ctx, bqclient, deleteSQL(mode, sqlTable(bqTable), sqlTable(ids), cf.Schema))
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case DeleteSoft:
+		fmt.Printf("Marked %d deleted records\n", affectedRows(status))
+	default:
+		fmt.Printf("Removed %d deleted records\n", affectedRows(status))
+	}
+	return nil
+}
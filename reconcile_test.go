@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestDeleteSQL(t *testing.T) {
+	ts := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_created_at": {SourceField: ":created_at", Type: bigquery.TimestampFieldType, Required: true, TimePartition: TimePartitionDay},
+	}
+	tests := []struct {
+		mode   DeleteMode
+		schema TableSchema
+		expect string
+	}{
+		{DeleteHard, TableSchema{}, "DELETE FROM `p.d.t` WHERE _id NOT IN (SELECT _id FROM `p.d.ids`)"},
+		{DeleteHard, ts, "DELETE FROM `p.d.t` WHERE _id NOT IN (SELECT _id FROM `p.d.ids`) AND `_created_at` IS NOT NULL"},
+		{DeleteSoft, TableSchema{}, "UPDATE `p.d.t` SET _deleted_at = CURRENT_TIMESTAMP() WHERE _id NOT IN (SELECT _id FROM `p.d.ids`) AND _deleted_at IS NULL"},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			got := deleteSQL(tc.mode, "`p.d.t`", "`p.d.ids`", tc.schema)
+			if got != tc.expect {
+				t.Fatalf("got %q expected %q", got, tc.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// RejectsTable as the Rejects setting loads rejected rows into the <TableName>_rejects BigQuery table
+const RejectsTable = "TABLE"
+
+// RejectedRow is a Socrata record with invalid values. The row was skipped when an error
+// has on_error SKIP_ROW, otherwise it was loaded with the SKIP_VALUE values set to null.
+type RejectedRow struct {
+	DatasetID  string          `json:"dataset_id"`
+	RowID      string          `json:"row_id"`
+	RejectedAt string          `json:"rejected_at"`
+	Errors     []RejectedValue `json:"errors"`
+	Record     string          `json:"record"` // the Socrata record as JSON
+}
+
+type RejectedValue struct {
+	Field       string `json:"field"`
+	SourceField string `json:"source_field"`
+	Value       string `json:"value"` // the source value as JSON
+	OnError     string `json:"on_error"`
+	Error       string `json:"error"`
+}
+
+var rejectsSchema = bigquery.Schema{
+	{Name: "dataset_id", Type: bigquery.StringFieldType, Required: true},
+	{Name: "row_id", Type: bigquery.StringFieldType},
+	{Name: "rejected_at", Type: bigquery.TimestampFieldType, Required: true},
+	{Name: "errors", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "field", Type: bigquery.StringFieldType},
+		{Name: "source_field", Type: bigquery.StringFieldType},
+		{Name: "value", Type: bigquery.StringFieldType},
+		{Name: "on_error", Type: bigquery.StringFieldType},
+		{Name: "error", Type: bigquery.StringFieldType},
+	}},
+	{Name: "record", Type: bigquery.StringFieldType},
+}
+
+// NewRejectedRow builds the dead-letter record for a row with errs
+func NewRejectedRow(datasetID string, row Record, errs []FieldError, now time.Time) RejectedRow {
+	r := RejectedRow{DatasetID: datasetID, RejectedAt: now.UTC().Format(time.RFC3339)}
+	r.RowID, _ = row[":id"].(string)
+	if b, err := json.Marshal(row); err == nil {
+		r.Record = string(b)
+	}
+	for _, fe := range errs {
+		v := RejectedValue{Field: fe.Field, SourceField: fe.SourceField, OnError: string(fe.Policy()), Error: fe.Err.Error()}
+		if b, err := json.Marshal(fe.Value); err == nil {
+			v.Value = string(b)
+		}
+		r.Errors = append(r.Errors, v)
+	}
+	return r
+}
+
+// rejectSink writes rows with values skipped by on_error to a local file, a GCS object or
+// (via a staged file and load job) the <TableName>_rejects BigQuery table
+type rejectSink struct {
+	name      string
+	datasetID string
+	w         *jsonWriter
+	closer    io.Closer
+	staged    stagedFile
+	table     *bigquery.Table
+	rows      int64
+}
+
+// newRejectSink returns the sink configured by Rejects, or nil if it is not set
+func newRejectSink(ctx context.Context, cf ConfigFile, client *storage.Client, st stager, dataset *bigquery.Dataset) (*rejectSink, error) {
+	r := &rejectSink{datasetID: cf.DatasetID()}
+	switch {
+	case cf.Rejects == "":
+		return nil, nil
+	case cf.Rejects == RejectsTable:
+		name := filepath.Join("socrata_to_bigquery", time.Now().Format("20060102-150405"), r.datasetID+"-rejects.json.gz")
+		f, err := st.Create(ctx, name, FormatJSON)
+		if err != nil {
+			return nil, err
+		}
+		r.staged, r.closer = f, f
+		r.table = dataset.Table(cf.BigQuery.TableName + "_rejects")
+		r.name = fmt.Sprintf("%s (%s)", r.table.TableID, f)
+		r.w = newJSONWriter(f, true)
+	case strings.HasPrefix(cf.Rejects, "gs://"):
+		bucket, object, _ := strings.Cut(strings.TrimPrefix(cf.Rejects, "gs://"), "/")
+		// GCS objects can not be appended to so each run writes its own object
+		object = runObjectName(object, time.Now())
+		w := client.Bucket(bucket).Object(object).NewWriter(ctx)
+		w.ContentType = "application/json"
+		if strings.HasSuffix(object, ".gz") {
+			w.ContentEncoding = "gzip"
+		}
+		r.name, r.closer = fmt.Sprintf("gs://%s/%s", bucket, object), w
+		r.w = newJSONWriter(w, strings.HasSuffix(object, ".gz"))
+	default:
+		path, err := outputPath(cf.Rejects)
+		if err != nil || path == "-" {
+			return nil, fmt.Errorf("invalid Rejects %q", cf.Rejects)
+		}
+		// rows rejected by earlier runs are kept; gzip readers decode the appended streams as one
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		r.name, r.closer = path, f
+		r.w = newJSONWriter(f, strings.HasSuffix(path, ".gz"))
+	}
+	return r, nil
+}
+
+// runObjectName adds the run time to an object name before its extensions,
+// i.e. "rejects/abcd-1234.jsonl.gz" becomes "rejects/abcd-1234-20240102-030405.jsonl.gz"
+func runObjectName(object string, t time.Time) string {
+	dir, base := path.Split(object)
+	name, ext, _ := strings.Cut(base, ".")
+	if ext != "" {
+		ext = "." + ext
+	}
+	return dir + name + "-" + t.Format("20060102-150405") + ext
+}
+
+func (r *rejectSink) String() string {
+	return r.name
+}
+
+// Write records a row with errs
+func (r *rejectSink) Write(row Record, errs []FieldError) error {
+	r.rows++
+	rr := NewRejectedRow(r.datasetID, row, errs, time.Now())
+	return r.w.enc.Encode(rr)
+}
+
+// Close flushes the rejected rows. With Rejects = TABLE they are then appended to
+// the rejects table, which is created if needed.
+func (r *rejectSink) Close(ctx context.Context) error {
+	if err := r.w.Close(); err != nil {
+		return err
+	}
+	if err := r.closer.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d rejected rows to %s\n", r.rows, r)
+	if r.table == nil {
+		return nil
+	}
+	if r.rows == 0 {
+		return r.staged.Remove(ctx)
+	}
+	err := r.table.Create(ctx, &bigquery.TableMetadata{
+		Name:        r.table.TableID,
+		Description: "rows with values skipped by on_error during sync",
+		Schema:      rejectsSchema,
+	})
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusConflict {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	src, err := r.staged.LoadSource()
+	if err != nil {
+		return err
+	}
+	loader := r.table.LoaderFrom(src)
+	loader.WriteDisposition = bigquery.WriteAppend
+	if err := runLoader(ctx, loader); err != nil {
+		return err
+	}
+	return r.staged.Remove(ctx)
+}
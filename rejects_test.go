@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRejectedRow(t *testing.T) {
+	row := Record{":id": "row-1", "day": "2024-13-01"}
+	errs := []FieldError{{Field: "day", SourceField: "day", Value: "2024-13-01", Err: errors.New("month out of range")}}
+	got := NewRejectedRow("abcd-1234", row, errs, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	expect := RejectedRow{
+		DatasetID:  "abcd-1234",
+		RowID:      "row-1",
+		RejectedAt: "2024-01-02T03:04:05Z",
+		Errors:     []RejectedValue{{Field: "day", SourceField: "day", Value: `"2024-13-01"`, OnError: "SKIP_ROW", Error: "month out of range"}},
+		Record:     `{":id":"row-1","day":"2024-13-01"}`,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %#v expected %#v", got, expect)
+	}
+
+	// a row loaded with a SKIP_VALUE value set to null is marked by on_error
+	errs = []FieldError{{Field: "day", SourceField: "day", Value: "2024-13-01", OnError: SkipValue, Err: errors.New("month out of range")}}
+	got = NewRejectedRow("abcd-1234", row, errs, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if len(got.Errors) != 1 || got.Errors[0].OnError != "SKIP_VALUE" {
+		t.Fatalf("got %#v expected on_error SKIP_VALUE", got.Errors)
+	}
+}
+
+func TestRejectSink_Local(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rejects.jsonl")
+	cf := ConfigFile{Config: Config{Dataset: "https://data.example.com/d/abcd-1234", Rejects: path}}
+	// a second run appends to the rows rejected by the first
+	for _, run := range [][]string{{"row-1", "row-2"}, {"row-3"}} {
+		r, err := newRejectSink(ctx, cf, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range run {
+			if err := r.Write(Record{":id": id}, []FieldError{{Field: "day", Err: errors.New("invalid")}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rr RejectedRow
+		if err := json.Unmarshal(scanner.Bytes(), &rr); err != nil {
+			t.Fatal(err)
+		}
+		if rr.DatasetID != "abcd-1234" || len(rr.Errors) != 1 {
+			t.Errorf("unexpected rejected row %#v", rr)
+		}
+		ids = append(ids, rr.RowID)
+	}
+	if !reflect.DeepEqual(ids, []string{"row-1", "row-2", "row-3"}) {
+		t.Fatalf("got %v", ids)
+	}
+}
+
+func TestRunObjectName(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		object string
+		expect string
+	}{
+		{"rejects/abcd-1234.jsonl.gz", "rejects/abcd-1234-20240102-030405.jsonl.gz"},
+		{"rejects.jsonl", "rejects-20240102-030405.jsonl"},
+		{"a.b/rejects", "a.b/rejects-20240102-030405"},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if got := runObjectName(tc.object, now); got != tc.expect {
+				t.Fatalf("got %q expected %q", got, tc.expect)
+			}
+		})
+	}
+}
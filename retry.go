@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed Socrata API requests are retried
+type RetryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     4,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+// socrataRetry is the policy applied to all Socrata API requests
+var socrataRetry = DefaultRetryPolicy
+
+// HTTPError is a non-200 response from the Socrata API
+type HTTPError struct {
+	StatusCode int
+	URL        string
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http status %d %s: %s", e.StatusCode, e.URL, e.Body)
+}
+
+// Retryable reports whether the request may succeed if tried again. Errors
+// such as 400 (bad SoQL), 403 (bad token) and 404 are fatal.
+func (e *HTTPError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Backoff returns the delay before retry number attempt (starting at 1)
+// using exponential backoff with jitter.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// "equal jitter"; wait between d/2 and d
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter parses a Retry-After header which is either a number of seconds or an HTTP date
+func retryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// doRequest sends the request built by newRequest, retrying network errors and
+// retryable HTTP status codes according to the policy. A new request is built for
+// each attempt so request bodies can be re-sent. The caller must close the response body.
+func doRequest(ctx context.Context, p RetryPolicy, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		var wait time.Duration
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+		} else if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		} else {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			_ = resp.Body.Close()
+			httpErr := &HTTPError{StatusCode: resp.StatusCode, URL: req.URL.String(), Body: body}
+			if !httpErr.Retryable() {
+				return nil, httpErr
+			}
+			wait = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			err = httpErr
+		}
+		if attempt > p.MaxRetries {
+			if p.MaxRetries > 0 {
+				return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return nil, err
+		}
+		if wait == 0 {
+			wait = p.Backoff(attempt)
+		}
+		log.Printf("retrying in %s (attempt %d of %d) %s", wait.Truncate(time.Millisecond), attempt, p.MaxRetries+1, err)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
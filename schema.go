@@ -18,6 +18,21 @@ const (
 	RaiseError OnError = "ERROR"
 )
 
+type SyncMode string
+
+const (
+	SyncAppend  SyncMode = "APPEND"
+	SyncMerge   SyncMode = "MERGE"
+	SyncReplace SyncMode = "REPLACE"
+)
+
+type DeleteMode string
+
+const (
+	DeleteHard DeleteMode = "HARD"
+	DeleteSoft DeleteMode = "SOFT"
+)
+
 type TimePartition string
 
 const (
@@ -33,11 +48,24 @@ type SchemaField struct {
 	SourceFieldType string             `toml:"source_field_type,omitempty"`
 	Description     string             `toml:"description,omitempty"`
 	Type            bigquery.FieldType `toml:"bigquery_type"`
-	TimeFormat      string             `comment:"the time.Parse format string" toml:"time_format,omitempty"`
+	Trim            bool               `comment:"remove leading and trailing whitespace" toml:"trim,omitempty"`
+	Case            Case               `comment:"UPPER | LOWER" toml:"case,omitempty"`
+	RegexReplace    string             `comment:"replace matches of this regular expression with replacement" toml:"regex_replace,omitempty"`
+	Replacement     string             `comment:"the replacement for regex_replace; $1 is the first submatch" toml:"replacement,omitempty"`
+	RegexExtract    string             `comment:"keep the first submatch (or the match) of this regular expression" toml:"regex_extract,omitempty"`
+	NullValues      []string           `comment:"values loaded as null (after trim, case and regex)" toml:"null_values,omitempty"`
+	ValueMap        map[string]string  `comment:"replace values (after trim, case and regex)" toml:"value_map,omitempty"`
+	Scale           *int               `comment:"digits kept after the decimal point (default 9 for NUMERIC, 38 for BIGNUMERIC)" toml:"scale,omitempty"`
+	Rounding        Rounding           `comment:"TRUNCATE (default) | HALF_EVEN how digits beyond scale are removed" toml:"rounding,omitempty"`
+	TimeFormat      TimeFormats        `comment:"time.Parse format strings, tried in order" toml:"time_format,omitempty"`
+	Timezone        string             `comment:"IANA time zone (i.e. America/New_York) of values without a UTC offset; default UTC" toml:"timezone,omitempty"`
 	TimePartition   TimePartition      `comment:"HOUR | DAY | MONTH | YEAR" toml:"time_partition,omitempty"`
 	Required        bool               `toml:"required"`
 	OnError         OnError            `comment:"SKIP_VALUE | SKIP_ROW | ERROR " toml:"on_error,omitempty"`
+	MaxErrors       ErrorBudget        `comment:"fail the sync when more than N (or N%) of values are invalid" toml:"max_errors,omitempty"`
 	ExampleValues   string             `commented:"true" toml:"example_values,omitempty"`
+	Profile         string             `commented:"true" toml:"profile,omitempty"`
+	Computed        *ComputedField     `toml:"-"` // set for fields from the [computed] section
 }
 
 type TableSchema map[string]SchemaField
@@ -45,20 +73,66 @@ type TableSchema map[string]SchemaField
 type Config struct {
 	Dataset                 string `comment:"The URL to the Socrata dataset"`
 	GoogleStorageBucketName string
+	Staging                 Staging     `comment:"GCS stages files in GoogleStorageBucketName; LOCAL stages them in a temp directory and uploads them with the load job (default LOCAL when there is no bucket)"`
+	AddNewColumns           bool        `comment:"add columns new in Socrata to this file and the BigQuery table as nullable fields when syncing"`
+	StagingFormat           FileFormat  `comment:"JSON (default) | PARQUET the format records are staged in for BigQuery load jobs"`
+	MaxErrors               ErrorBudget `comment:"fail the sync when more than N (or N%) of rows have invalid values"`
+	Rejects                 string      `comment:"write rows with values skipped by on_error to a local path, a gs://bucket/path object or TABLE (the <TableName>_rejects BigQuery table)"`
+	PageSize                int64       `comment:"request records from Socrata N rows at a time (0 requests all records at once)"`
+	PageOrder               PageOrder   `comment:":created_at (default) | :id the order records are paged and checkpointed in"`
+	CheckpointRows          int64       `comment:"load every N rows and record progress so an interrupted sync can resume (0 disables)"`
+	CheckpointFile          string      `comment:"local path or gs://bucket/path for checkpoint state (defaults to the config filename + .checkpoint.json)"`
 	BigQuery                BigQuery
 }
 
+// Order returns the configured PageOrder, defaulting to PageByCreatedAt
+func (c Config) Order() PageOrder {
+	if c.PageOrder == "" {
+		return PageByCreatedAt
+	}
+	return c.PageOrder
+}
+
 func (c Config) GSBucket() string {
 	return "gs://" + c.GoogleStorageBucketName
 }
 
+// Format returns the configured StagingFormat, defaulting to FormatJSON
+func (c Config) Format() FileFormat {
+	if c.StagingFormat == "" {
+		return FormatJSON
+	}
+	return c.StagingFormat
+}
+
+// StagingMethod returns the configured Staging, defaulting to GCS when a bucket is configured
+func (c Config) StagingMethod() Staging {
+	switch {
+	case c.Staging != "":
+		return c.Staging
+	case c.GoogleStorageBucketName == "":
+		return StagingLocal
+	}
+	return StagingGCS
+}
+
 // BigQuery Settings
 type BigQuery struct {
 	ProjectID   string
 	DatasetName string
 	TableName   string
 	Description string
-	WhereFilter string `comment:"restrict sync to $where=..."`
+	WhereFilter string     `comment:"restrict sync to $where=..."`
+	SyncMode    SyncMode   `comment:"APPEND (default) loads records created since the last sync; MERGE also updates records modified since the last sync; REPLACE reloads the full dataset"`
+	DeleteMode  DeleteMode `comment:"remove records deleted from Socrata. HARD deletes them; SOFT sets _deleted_at"`
+}
+
+// Mode returns the configured SyncMode, defaulting to SyncAppend
+func (bq BigQuery) Mode() SyncMode {
+	if bq.SyncMode == "" {
+		return SyncAppend
+	}
+	return bq.SyncMode
 }
 
 func (bq BigQuery) SQLTableName() string {
@@ -67,7 +141,18 @@ func (bq BigQuery) SQLTableName() string {
 
 type ConfigFile struct {
 	Config
-	Schema TableSchema `toml:"schema"`
+	Schema   TableSchema    `toml:"schema"`
+	Computed ComputedSchema `toml:"computed,omitempty"`
+
+	filename string
+}
+
+// CheckpointPath returns where sync progress is recorded
+func (cf ConfigFile) CheckpointPath() string {
+	if cf.CheckpointFile != "" {
+		return cf.CheckpointFile
+	}
+	return cf.filename + ".checkpoint.json"
 }
 
 func (cf ConfigFile) DatasetID() string {
@@ -91,7 +176,26 @@ func LoadConfigFile(name string) (ConfigFile, error) {
 	}
 	defer func() { _ = f.Close() }()
 	err = toml.NewDecoder(f).Decode(&cf)
-	return cf, err
+	cf.filename = name
+	if err != nil {
+		return cf, err
+	}
+	for fieldName, field := range cf.Schema {
+		if t, ok := fieldTypeAliases[field.Type]; ok {
+			field.Type = t
+			cf.Schema[fieldName] = field
+		}
+	}
+	for name, c := range cf.Computed {
+		if t, ok := fieldTypeAliases[c.Type]; ok {
+			c.Type = t
+			cf.Computed[name] = c
+		}
+	}
+	if len(cf.Computed) > 0 && cf.Schema == nil {
+		cf.Schema = make(TableSchema)
+	}
+	return cf, addComputedFields(cf.Schema, cf.Computed)
 }
 
 func ToTableName(id, name string) string {
@@ -109,29 +213,33 @@ func NewConfig(datasetURL string, md SocrataMetadata) Config {
 	}
 }
 
-func GuessBQType(t, name string) (bigquery.FieldType, string) {
+// GuessBQType returns the BigQuery type and time_format for a Socrata column type.
+// Unknown Socrata types are an error so new types can be skipped.
+func GuessBQType(t, name string) (bigquery.FieldType, string, error) {
 	switch t {
-	case "text", "url":
-		if strings.Contains(name, "date") {
-			return bigquery.DateFieldType, "2006/01/02"
-		}
-		if strings.Contains(name, "time") {
-			// TODO better guessing
-			return bigquery.TimeFieldType, "03:04pm"
-		}
-		return bigquery.StringFieldType, ""
-	case "number":
-		return bigquery.NumericFieldType, ""
-	case "calendar_date":
-		return bigquery.DateTimeFieldType, "2006-01-02T15:04:05.000"
-	case "point":
-		return bigquery.GeographyFieldType, ""
-	case "location":
-		return bigquery.GeographyFieldType, ""
+	case "text":
+		// dates and times in text columns are found by profileSchema
+		return bigquery.StringFieldType, "", nil
+	case "url", "phone", "document":
+		// intentionally STRING only: objectString keeps the url or phone number and
+		// JSON encodes a document. There is no option to load them as a RECORD.
+		return bigquery.StringFieldType, "", nil
+	case "email", "photo", "html", "blob":
+		return bigquery.StringFieldType, "", nil
+	case "number", "money", "percent":
+		return bigquery.NumericFieldType, "", nil
+	case "double":
+		return bigquery.FloatFieldType, "", nil
+	case "calendar_date", "floating_timestamp":
+		return bigquery.DateTimeFieldType, "2006-01-02T15:04:05.000", nil
+	case "fixed_timestamp":
+		return bigquery.TimestampFieldType, "", nil
+	case "point", "location", "multipoint", "line", "multiline", "polygon", "multipolygon":
+		return bigquery.GeographyFieldType, "", nil
 	case "checkbox":
-		return bigquery.BooleanFieldType, ""
+		return bigquery.BooleanFieldType, "", nil
 	}
-	panic(fmt.Sprintf("unknown type %q", t))
+	return "", "", fmt.Errorf("unsupported Socrata type %q", t)
 }
 
 func NewSchema(s SocrataMetadata, examples map[string]string) TableSchema {
@@ -162,33 +270,52 @@ func NewSchema(s SocrataMetadata, examples map[string]string) TableSchema {
 		},
 	}
 	for _, c := range s.Columns {
-		switch c.FieldName {
-		case ":id", ":created_at", ":updated_at", ":version":
+		if skipSocrataColumn(c) {
 			continue
 		}
-		switch c.DataTypeName {
-		case "meta_data":
+		f, err := NewSchemaField(c, examples[c.FieldName])
+		if err != nil {
+			fmt.Printf("> skipping column %q: %s\n", c.FieldName, err)
 			continue
 		}
-		fieldType, timeFormat := GuessBQType(c.DataTypeName, c.FieldName)
-		var oe OnError
-		if timeFormat != "" {
-			oe = SkipValue
-		}
-		t[c.FieldName] = SchemaField{
-			SourceField:     c.FieldName,
-			SourceFieldType: c.DataTypeName,
-			Type:            fieldType,
-			TimeFormat:      timeFormat,
-			Required:        false,
-			Description:     strings.TrimSpace(c.Name),
-			ExampleValues:   examples[c.FieldName],
-			OnError:         oe,
-		}
+		t[c.FieldName] = f
 	}
 	return t
 }
 
+// skipSocrataColumn reports columns which are not mapped to a schema field of their own
+func skipSocrataColumn(c SocrataColumn) bool {
+	switch c.FieldName {
+	case ":id", ":created_at", ":updated_at", ":version":
+		return true
+	}
+	return c.DataTypeName == "meta_data"
+}
+
+// NewSchemaField returns the default nullable schema field for a Socrata column
+func NewSchemaField(c SocrataColumn, example string) (SchemaField, error) {
+	fieldType, timeFormat, err := GuessBQType(c.DataTypeName, c.FieldName)
+	if err != nil {
+		return SchemaField{}, err
+	}
+	var oe OnError
+	var formats TimeFormats
+	if timeFormat != "" {
+		oe = SkipValue
+		formats = TimeFormats{timeFormat}
+	}
+	return SchemaField{
+		SourceField:     c.FieldName,
+		SourceFieldType: c.DataTypeName,
+		Type:            fieldType,
+		TimeFormat:      formats,
+		Required:        false,
+		Description:     strings.TrimSpace(c.Name),
+		ExampleValues:   example,
+		OnError:         oe,
+	}, nil
+}
+
 func (t TableSchema) BigQuerySchema() bigquery.Schema {
 	var s bigquery.Schema
 	for name, schema := range t {
@@ -256,10 +383,19 @@ func (t TableSchema) TimePartitioning() (*bigquery.TimePartitioning, error) {
 	}, nil
 }
 
-func (t TableSchema) PartitionWhereClause() string {
+// PartitionFilter returns a condition on the time partitioning field that satisfies
+// require_partition_filter without excluding any rows, or "" for unpartitioned tables
+func (t TableSchema) PartitionFilter() string {
 	tp, err := t.TimePartitioning()
 	if err != nil || tp == nil {
 		return ""
 	}
-	return fmt.Sprintf("WHERE %s IS NOT NULL", bqIdentifier(tp.Field))
+	return fmt.Sprintf("%s IS NOT NULL", bqIdentifier(tp.Field))
+}
+
+func (t TableSchema) PartitionWhereClause() string {
+	if f := t.PartitionFilter(); f != "" {
+		return "WHERE " + f
+	}
+	return ""
 }
@@ -15,6 +15,12 @@ func usage() {
 	fmt.Println(" - init")
 	fmt.Println(" - sync")
 	fmt.Println(" - archive")
+	fmt.Println(" - diff-schema")
+	fmt.Println(" - validate")
+	fmt.Println(" - test-transform")
+	fmt.Println(" - verify")
+	fmt.Println(" - backfill")
+	fmt.Println(" - dedupe")
 }
 
 func main() {
@@ -32,6 +38,18 @@ func main() {
 		syncCmd(os.Args[2:])
 	case "archive":
 		archiveCmd(os.Args[2:])
+	case "diff-schema":
+		diffSchemaCmd(os.Args[2:])
+	case "validate":
+		validateCmd(os.Args[2:])
+	case "test-transform":
+		testTransformCmd(os.Args[2:])
+	case "verify":
+		verifyCmd(os.Args[2:])
+	case "backfill":
+		backfillCmd(os.Args[2:])
+	case "dedupe":
+		dedupeCmd(os.Args[2:])
 	default:
 		usage()
 		os.Exit(1)
@@ -42,9 +60,16 @@ func syncCmd(args []string) {
 	flagSet := flag.NewFlagSet(fmt.Sprintf("%s sync", os.Args[0]), flag.ExitOnError)
 	quiet := flagSet.Bool("quiet", false, "disable progress output")
 	token := flagSet.String("socrata-app-token", "", "Socrata App Token (also src SOCRATA_APP_TOKEN env)")
+	retries := flagSet.Int("socrata-retries", DefaultRetryPolicy.MaxRetries, "number of times to retry failed Socrata API requests")
+	fullRefresh := flagSet.Bool("full-refresh", false, "reload the full dataset and atomically replace the BigQuery table (same as SyncMode = \"REPLACE\")")
+	dryRun := flagSet.Bool("dry-run", false, "transform sample rows and report invalid values without loading them (see test-transform)")
+	sampleRows := flagSet.Int64("sample-rows", 100, "number of rows to sample with -dry-run")
+	verify := flagSet.Bool("verify", false, "compare Socrata and BigQuery counts (total and per day) after each sync and exit non-zero when they differ")
+	output := flagSet.String("output", "", "write transformed records to file:///path/out.jsonl[.gz] or - (stdout) instead of loading them into BigQuery")
 	if err := flagSet.Parse(args); err != nil {
 		log.Fatal(err)
 	}
+	socrataRetry.MaxRetries = *retries
 	if *token == "" {
 		*token = os.Getenv("SOCRATA_APP_TOKEN")
 	}
@@ -57,7 +82,27 @@ func syncCmd(args []string) {
 		fmt.Fprintln(os.Stderr, "missing filename")
 		os.Exit(1)
 	}
+	if *dryRun {
+		for _, configFile := range flagSet.Args() {
+			testTransformOne(configFile, *token, "", *sampleRows, true)
+		}
+		return
+	}
+	if *output != "" {
+		if flagSet.NArg() > 1 {
+			fmt.Fprintln(os.Stderr, "-output supports a single config file")
+			os.Exit(1)
+		}
+		exportOne(flagSet.Arg(0), *quiet, *token, *output)
+		return
+	}
+	var failed bool
 	for _, configFile := range flagSet.Args() {
-		syncOne(configFile, *quiet, *token)
+		if !syncOne(configFile, *quiet, *token, *fullRefresh, *verify) {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
 	}
 }
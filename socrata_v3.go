@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -56,43 +57,34 @@ func v3Post(ctx context.Context, apiBase *url.URL, datasetID, sql, token string)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v3Endpoint(apiBase, datasetID), bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	setSocrataToken(req, token)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != http.StatusOK {
-		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		_ = resp.Body.Close()
-		return nil, fmt.Errorf("http status %d %s: %s", resp.StatusCode, v3Endpoint(apiBase, datasetID), errBody)
-	}
-	return resp, nil
+	return doRequest(ctx, socrataRetry, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, v3Endpoint(apiBase, datasetID), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		setSocrataToken(req, token)
+		return req, nil
+	})
 }
 
 // FetchMetadata retrieves dataset metadata from the Socrata API.
 func FetchMetadata(ctx context.Context, apiBase *url.URL, datasetID, token string) (*SocrataMetadata, error) {
 	u := *apiBase
 	u.Path = fmt.Sprintf("/api/views/%s.json", url.PathEscape(datasetID))
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-	if err != nil {
-		return nil, err
-	}
-	setSocrataToken(req, token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(ctx, socrataRetry, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		setSocrataToken(req, token)
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("http status %d: %s", resp.StatusCode, body)
-	}
 	var md SocrataMetadata
 	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
 		return nil, err
@@ -203,3 +195,102 @@ func StreamV3(ctx context.Context, apiBase *url.URL, datasetID, sql, token strin
 		return nil
 	}
 }
+
+// PageOrder is the keyset used to walk a dataset in a stable order
+type PageOrder string
+
+const (
+	PageByCreatedAt PageOrder = ":created_at"
+	PageByID        PageOrder = ":id"
+)
+
+// OrderBy returns the SoQL ORDER BY expression for the keyset
+func (o PageOrder) OrderBy() string {
+	if o == PageByID {
+		return ":id"
+	}
+	return ":created_at, :id"
+}
+
+// Cursor returns the keyset position of a Socrata record
+func (o PageOrder) Cursor(r Record) Cursor {
+	var c Cursor
+	c.ID, _ = r[":id"].(string)
+	if o != PageByID {
+		c.CreatedAt, _ = r[":created_at"].(string)
+	}
+	return c
+}
+
+// handlerError marks an error returned by a row handler so it is not retried
+type handlerError struct{ error }
+
+func (e handlerError) Unwrap() error { return e.error }
+
+// retryablePage reports whether a failed page might succeed if requested again.
+// HTTP errors have already been retried by doRequest; this covers responses that
+// fail part way through (i.e. a truncated body or connection reset).
+func retryablePage(err error) bool {
+	var httpErr *HTTPError
+	var hErr handlerError
+	switch {
+	case errors.As(err, &hErr), errors.As(err, &httpErr):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+// StreamPagedV3 streams the records matching where in pages of at most pageSize rows
+// using keyset pagination in the given order, starting after the cursor. Each page
+// is requested (and retried) independently but handle sees one continuous stream of rows.
+// A pageSize of 0 requests every record at once; a response that fails part way through
+// is still requested again after the last row delivered.
+// The selected columns must include those used by the order (:id, and :created_at).
+func StreamPagedV3(ctx context.Context, apiBase *url.URL, datasetID, sel, where, token string, order PageOrder, pageSize int64, after Cursor, handle func(Record) error) error {
+	cursor := after
+	pages, retries := 1, 0
+	for {
+		sql := sel
+		if w := andWhere(where, cursor.Where()); w != "" {
+			sql += " WHERE " + w
+		}
+		sql += " ORDER BY " + order.OrderBy()
+		if pageSize > 0 {
+			sql += fmt.Sprintf(" LIMIT %d", pageSize)
+		}
+
+		var rows int64
+		err := StreamV3(ctx, apiBase, datasetID, sql, token, func(row Record) error {
+			if err := handle(row); err != nil {
+				return handlerError{err}
+			}
+			rows++
+			cursor = order.Cursor(row)
+			return nil
+		})
+		if err != nil {
+			if !retryablePage(err) || retries >= socrataRetry.MaxRetries {
+				var hErr handlerError
+				if errors.As(err, &hErr) {
+					return hErr.error
+				}
+				return fmt.Errorf("page %d: %w", pages, err)
+			}
+			retries++
+			wait := socrataRetry.Backoff(retries)
+			// the page restarts after the last row delivered, so no rows are repeated
+			log.Printf("retrying page %d after %s in %s (attempt %d of %d) %s", pages, cursor, wait.Truncate(time.Millisecond), retries, socrataRetry.MaxRetries+1, err)
+			if err := sleepContext(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+		if pageSize <= 0 || rows < pageSize {
+			return nil
+		}
+		pages++
+		retries = 0
+	}
+}
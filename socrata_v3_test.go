@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRetryPolicy swaps in a retry policy with short delays for the duration of a test
+func testRetryPolicy(t *testing.T, maxRetries int) {
+	orig := socrataRetry
+	socrataRetry = RetryPolicy{MaxRetries: maxRetries, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	t.Cleanup(func() { socrataRetry = orig })
+}
+
+func testServer(t *testing.T, h http.HandlerFunc) *url.URL {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestFetchMetadata_Retry(t *testing.T) {
+	testRetryPolicy(t, 4)
+	var calls atomic.Int32
+	apiBase := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/api/views/abcd-1234.json" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"id":"abcd-1234","name":"Test"}`))
+	})
+
+	md, err := FetchMetadata(context.Background(), apiBase, "abcd-1234", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.ID != "abcd-1234" {
+		t.Fatalf("unexpected metadata %#v", md)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls got %d", calls.Load())
+	}
+}
+
+func TestCountV3_FatalError(t *testing.T) {
+	testRetryPolicy(t, 4)
+	var calls atomic.Int32
+	apiBase := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message":"bad query"}`))
+	})
+
+	_, err := CountV3(context.Background(), apiBase, "abcd-1234", "bogus ==", "token")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected HTTPError 400 got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 call got %d", calls.Load())
+	}
+}
+
+func TestCountV3_RetryAfter(t *testing.T) {
+	testRetryPolicy(t, 1)
+	var calls atomic.Int32
+	apiBase := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if r.Header.Get("X-App-Token") != "token" {
+			t.Errorf("missing app token")
+		}
+		_, _ = w.Write([]byte(`[{"count":"42"}]`))
+	})
+
+	n, err := CountV3(context.Background(), apiBase, "abcd-1234", "", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 42 {
+		t.Fatalf("expected 42 got %d", n)
+	}
+}
+
+func TestStreamV3_GiveUp(t *testing.T) {
+	testRetryPolicy(t, 2)
+	var calls atomic.Int32
+	apiBase := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	err := StreamV3(context.Background(), apiBase, "abcd-1234", "SELECT :*, *", "token", func(Record) error { return nil })
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected HTTPError 502 got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls got %d", calls.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		have   string
+		expect time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"Tue, 02 Jan 2024 03:04:35 GMT", 30 * time.Second},
+		{"Tue, 02 Jan 2024 03:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tc := range tests {
+		if got := retryAfter(tc.have, now); got != tc.expect {
+			t.Errorf("retryAfter(%q) = %s expected %s", tc.have, got, tc.expect)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{10, 5 * time.Second, 10 * time.Second},
+	}
+	for _, tc := range tests {
+		for i := 0; i < 20; i++ {
+			if got := p.Backoff(tc.attempt); got < tc.min || got > tc.max {
+				t.Fatalf("Backoff(%d) = %s expected between %s and %s", tc.attempt, got, tc.min, tc.max)
+			}
+		}
+	}
+}
+
+func TestStreamPagedV3(t *testing.T) {
+	testRetryPolicy(t, 2)
+	var queries []string
+	responses := []string{
+		`[{":id":"row-1"},{":id":"row-2"}]`,
+		`[{":id":"row-3"},{":id":"ro`, // truncated response
+		`[{":id":"row-4"}]`,
+	}
+	apiBase := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body v3QueryBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		queries = append(queries, body.SQL)
+		_, _ = w.Write([]byte(responses[len(queries)-1]))
+	})
+
+	var ids []string
+	err := StreamPagedV3(context.Background(), apiBase, "abcd-1234", "SELECT :id", "a = 1", "token", PageByID, 2, Cursor{}, func(r Record) error {
+		ids = append(ids, r[":id"].(string))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ids, ","); got != "row-1,row-2,row-3,row-4" {
+		t.Fatalf("got rows %s", got)
+	}
+	expect := []string{
+		"SELECT :id WHERE a = 1 ORDER BY :id LIMIT 2",
+		"SELECT :id WHERE a = 1 AND :id > 'row-2' ORDER BY :id LIMIT 2",
+		"SELECT :id WHERE a = 1 AND :id > 'row-3' ORDER BY :id LIMIT 2",
+	}
+	if got, want := strings.Join(queries, "\n"), strings.Join(expect, "\n"); got != want {
+		t.Fatalf("got queries\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestStreamPagedV3_NoPageSize(t *testing.T) {
+	testRetryPolicy(t, 2)
+	var queries []string
+	responses := []string{
+		`[{":id":"row-1"},{":id":"ro`, // connection reset mid stream
+		`[{":id":"row-2"},{":id":"row-3"}]`,
+	}
+	apiBase := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body v3QueryBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		queries = append(queries, body.SQL)
+		_, _ = w.Write([]byte(responses[len(queries)-1]))
+	})
+
+	var ids []string
+	err := StreamPagedV3(context.Background(), apiBase, "abcd-1234", "SELECT :id", "", "token", PageByID, 0, Cursor{}, func(r Record) error {
+		ids = append(ids, r[":id"].(string))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ids, ","); got != "row-1,row-2,row-3" {
+		t.Fatalf("got rows %s", got)
+	}
+	expect := []string{
+		"SELECT :id ORDER BY :id",
+		"SELECT :id WHERE :id > 'row-1' ORDER BY :id",
+	}
+	if got, want := strings.Join(queries, "\n"), strings.Join(expect, "\n"); got != want {
+		t.Fatalf("got queries\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestStreamPagedV3_HandlerError(t *testing.T) {
+	testRetryPolicy(t, 2)
+	var calls atomic.Int32
+	apiBase := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`[{":id":"row-1",":created_at":"2024-01-02T03:04:05.000Z"}]`))
+	})
+	stop := errors.New("stop")
+	err := StreamPagedV3(context.Background(), apiBase, "abcd-1234", "SELECT :*, *", "", "token", PageByCreatedAt, 10, Cursor{}, func(Record) error {
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("expected handler error got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 call got %d", calls.Load())
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+)
+
+type Staging string
+
+const (
+	StagingGCS   Staging = "GCS"
+	StagingLocal Staging = "LOCAL"
+)
+
+type FileFormat string
+
+const (
+	FormatJSON    FileFormat = "JSON"
+	FormatParquet FileFormat = "PARQUET"
+)
+
+// Ext returns the file extension for staged files
+func (f FileFormat) Ext() string {
+	if f == FormatParquet {
+		return ".parquet"
+	}
+	return ".json.gz"
+}
+
+func (f FileFormat) SourceFormat() bigquery.DataFormat {
+	if f == FormatParquet {
+		return bigquery.Parquet
+	}
+	return bigquery.JSON
+}
+
+// stagedFile is where a chunk of records is written before it is loaded into BigQuery
+type stagedFile interface {
+	io.WriteCloser
+	// String returns the location of the file for logging
+	String() string
+	// LoadSource returns the BigQuery load source for the file once it is closed
+	LoadSource() (bigquery.LoadSource, error)
+	// Remove deletes the file
+	Remove(ctx context.Context) error
+}
+
+// stager creates staged files
+type stager interface {
+	Create(ctx context.Context, name string, format FileFormat) (stagedFile, error)
+}
+
+// gcsStager stages files as objects in a GCS bucket
+type gcsStager struct {
+	bucket string
+	bkt    *storage.BucketHandle
+}
+
+func (s gcsStager) Create(ctx context.Context, name string, format FileFormat) (stagedFile, error) {
+	obj := s.bkt.Object(name)
+	w := obj.NewWriter(ctx)
+	switch format {
+	case FormatParquet:
+		w.ContentType = "application/vnd.apache.parquet"
+	default:
+		w.ContentType = "application/json"
+		w.ContentEncoding = "gzip"
+	}
+	return &gcsFile{bucket: s.bucket, obj: obj, format: format, Writer: w}, nil
+}
+
+type gcsFile struct {
+	*storage.Writer
+	bucket string
+	obj    *storage.ObjectHandle
+	format FileFormat
+}
+
+func (f *gcsFile) String() string {
+	return fmt.Sprintf("gs://%s/%s", f.bucket, f.obj.ObjectName())
+}
+
+func (f *gcsFile) LoadSource() (bigquery.LoadSource, error) {
+	gcsRef := bigquery.NewGCSReference(f.String())
+	gcsRef.SourceFormat = f.format.SourceFormat()
+	return gcsRef, nil
+}
+
+func (f *gcsFile) Remove(ctx context.Context) error {
+	return f.obj.Delete(ctx)
+}
+
+// localStager stages files in a local temporary directory; they are uploaded with the load job
+type localStager struct {
+	dir string
+}
+
+func (s localStager) Create(ctx context.Context, name string, format FileFormat) (stagedFile, error) {
+	f, err := os.CreateTemp(s.dir, "socrata_to_bigquery-*-"+filepath.Base(name))
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{File: f, format: format}, nil
+}
+
+type localFile struct {
+	*os.File
+	r      *os.File
+	format FileFormat
+}
+
+func (f *localFile) String() string {
+	return f.Name()
+}
+
+func (f *localFile) LoadSource() (bigquery.LoadSource, error) {
+	r, err := os.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	f.r = r
+	src := bigquery.NewReaderSource(r)
+	src.SourceFormat = f.format.SourceFormat()
+	return src, nil
+}
+
+func (f *localFile) Remove(ctx context.Context) error {
+	if f.r != nil {
+		_ = f.r.Close()
+	}
+	return os.Remove(f.Name())
+}
+
+// newStager returns the stager for the configured Staging method. Staging
+// defaults to GCS when a GoogleStorageBucketName is configured and LOCAL otherwise.
+func newStager(cf ConfigFile, client *storage.Client) (stager, error) {
+	switch cf.Format() {
+	case FormatJSON, FormatParquet:
+	default:
+		return nil, fmt.Errorf("unknown StagingFormat %q", cf.StagingFormat)
+	}
+	switch cf.StagingMethod() {
+	case StagingGCS:
+		if cf.GoogleStorageBucketName == "" {
+			return nil, fmt.Errorf("Staging %s requires GoogleStorageBucketName", StagingGCS)
+		}
+		return gcsStager{bucket: cf.GoogleStorageBucketName, bkt: client.Bucket(cf.GoogleStorageBucketName)}, nil
+	case StagingLocal:
+		return localStager{}, nil
+	}
+	return nil, fmt.Errorf("unknown Staging %q", cf.Staging)
+}
+
+// stagedRow is a transformed record along with the position of the Socrata record it came from
+type stagedRow struct {
+	record Record
+	cursor Cursor
+}
+
+// recordWriter encodes transformed records. Close flushes buffered data
+// but does not close the underlying writer.
+type recordWriter interface {
+	Write(r Record) error
+	Close() error
+}
+
+func newRecordWriter(w io.Writer, format FileFormat, s TableSchema, compress bool) (recordWriter, error) {
+	if format == FormatParquet {
+		return newParquetWriter(w, s)
+	}
+	return newJSONWriter(w, compress), nil
+}
+
+// jsonWriter writes newline delimited JSON, optionally gzip compressed
+type jsonWriter struct {
+	bw  *bufio.Writer
+	gw  *gzip.Writer
+	enc *json.Encoder
+}
+
+func newJSONWriter(w io.Writer, compress bool) *jsonWriter {
+	j := &jsonWriter{bw: bufio.NewWriterSize(w, 5*1024*1024)} // 5MB buffer
+	if compress {
+		j.gw = gzip.NewWriter(j.bw)
+		j.enc = json.NewEncoder(j.gw)
+	} else {
+		j.enc = json.NewEncoder(j.bw)
+	}
+	j.enc.SetEscapeHTML(false)
+	return j
+}
+
+func (j *jsonWriter) Write(r Record) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonWriter) Close() error {
+	if j.gw != nil {
+		if err := j.gw.Close(); err != nil {
+			return err
+		}
+	}
+	return j.bw.Flush()
+}
+
+// chunk is a file of records staged for a BigQuery load job
+type chunk struct {
+	file   stagedFile
+	w      recordWriter
+	rows   int64
+	cursor Cursor
+}
+
+func newChunk(f stagedFile, format FileFormat, s TableSchema) (*chunk, error) {
+	w, err := newRecordWriter(f, format, s, true)
+	if err != nil {
+		return nil, err
+	}
+	return &chunk{file: f, w: w}, nil
+}
+
+func (c *chunk) Write(row stagedRow) error {
+	c.rows++
+	c.cursor = row.cursor
+	return c.w.Write(row.record)
+}
+
+func (c *chunk) Close() error {
+	if err := c.w.Close(); err != nil {
+		return err
+	}
+	return c.file.Close()
+}
+
+// Load appends the chunk to bqTable. The staged file is removed whether or not the load succeeds.
+func (c *chunk) Load(ctx context.Context, bqTable *bigquery.Table) (err error) {
+	defer func() {
+		if removeErr := c.file.Remove(ctx); err == nil {
+			err = removeErr
+		}
+	}()
+	fmt.Printf("Queued %d rows for BigQuery load\n", c.rows)
+	src, err := c.file.LoadSource()
+	if err != nil {
+		return err
+	}
+	loader := bqTable.LoaderFrom(src)
+	loader.WriteDisposition = bigquery.WriteAppend
+	return runLoader(ctx, loader)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestStagingMethod(t *testing.T) {
+	tests := []struct {
+		config Config
+		expect Staging
+	}{
+		{Config{}, StagingLocal},
+		{Config{GoogleStorageBucketName: "bucket"}, StagingGCS},
+		{Config{GoogleStorageBucketName: "bucket", Staging: StagingLocal}, StagingLocal},
+	}
+	for _, tc := range tests {
+		if got := tc.config.StagingMethod(); got != tc.expect {
+			t.Errorf("%#v got %s expected %s", tc.config, got, tc.expect)
+		}
+	}
+}
+
+func TestLocalStager(t *testing.T) {
+	ctx := context.Background()
+	f, err := localStager{dir: t.TempDir()}.Create(ctx, "socrata_to_bigquery/20240102-030405/abcd-1234.json.gz", FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newChunk(f, FormatJSON, TableSchema{"a": {Type: bigquery.StringFieldType}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(stagedRow{record: Record{"a": "<b>"}, cursor: Cursor{ID: "row-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.rows != 1 || c.cursor.ID != "row-1" {
+		t.Fatalf("unexpected chunk state %d %#v", c.rows, c.cursor)
+	}
+
+	r, err := os.Open(f.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "{\"a\":\"<b>\"}\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	src, err := f.LoadSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs, ok := src.(*bigquery.ReaderSource); !ok || rs.SourceFormat != bigquery.JSON {
+		t.Fatalf("unexpected load source %#v", src)
+	}
+	if err := f.Remove(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f.String()); !os.IsNotExist(err) {
+		t.Fatalf("expected file removed, got %v", err)
+	}
+}
+
+// failedFile is a staged file whose load source can not be opened
+type failedFile struct {
+	io.WriteCloser
+	removed bool
+}
+
+func (f *failedFile) String() string { return "failed" }
+func (f *failedFile) LoadSource() (bigquery.LoadSource, error) {
+	return nil, errors.New("load source failed")
+}
+func (f *failedFile) Remove(ctx context.Context) error {
+	f.removed = true
+	return nil
+}
+
+func TestChunkLoad_RemovesOnError(t *testing.T) {
+	f := &failedFile{}
+	c := &chunk{file: f}
+	if err := c.Load(context.Background(), nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if !f.removed {
+		t.Fatal("expected staged file removed")
+	}
+}
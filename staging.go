@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// sqlTable returns the fully qualified, quoted name of a table for use in a query
+func sqlTable(t *bigquery.Table) string {
+	return fmt.Sprintf("`%s.%s.%s`", t.ProjectID, t.DatasetID, t.TableID)
+}
+
+// stagingTableName returns a unique name for a temporary table derived from the target table name
+func stagingTableName(cf ConfigFile, kind string) string {
+	return fmt.Sprintf("%s_%s_%s", cf.BigQuery.TableName, kind, time.Now().Format("20060102_150405"))
+}
+
+// createStagingTable creates a short-lived table next to the target table.
+// Staging tables expire after 24 hours in case they are not cleaned up.
+func createStagingTable(ctx context.Context, dataset *bigquery.Dataset, name string, schema bigquery.Schema, timePartitioning *bigquery.TimePartitioning) (*bigquery.Table, error) {
+	t := dataset.Table(name)
+	fmt.Printf("> staging to table %s\n", name)
+	err := t.Create(ctx, &bigquery.TableMetadata{
+		Name:             name,
+		Schema:           schema,
+		TimePartitioning: timePartitioning,
+		ExpirationTime:   time.Now().Add(24 * time.Hour),
+	})
+	return t, err
+}
+
+// dropStagingTable removes a staging table, logging (but not failing on) errors
+func dropStagingTable(ctx context.Context, t *bigquery.Table) {
+	if err := t.Delete(ctx); err != nil {
+		log.Printf("error removing staging table %s: %s", t.TableID, err)
+	}
+}
+
+// runLoader runs a load job and waits for it to complete
+func runLoader(ctx context.Context, loader *bigquery.Loader) error {
+	loadJob, err := loader.Run(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("BigQuery import running job %s\n", loadJob.ID())
+	status, err := loadJob.Wait(ctx)
+	fmt.Printf("BigQuery import job %s done\n", loadJob.ID())
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// runQuery runs a query job (typically DML) and waits for it to complete
+func runQuery(ctx context.Context, bqclient *bigquery.Client, sql string) (*bigquery.JobStatus, error) {
+	job, err := bqclient.Query(sql).Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("BigQuery query running job %s\n", job.ID())
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = status.Err(); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// affectedRows returns the number of rows modified by a DML query job
+func affectedRows(status *bigquery.JobStatus) int64 {
+	if status == nil || status.Statistics == nil {
+		return 0
+	}
+	if qs, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+		return qs.NumDMLAffectedRows
+	}
+	return 0
+}
+
+// mergeSQL builds a MERGE statement that upserts rows from staging into target keyed on _id.
+// Only the most recently updated row for each _id in staging is used, as a chunk is loaded
+// into staging twice when a sync is interrupted between the load and the checkpoint.
+// With DeleteMode SOFT a matched row is no longer deleted, so its _deleted_at is cleared.
+func mergeSQL(target, staging string, s TableSchema, mode DeleteMode) string {
+	var columns []string
+	for name := range s {
+		columns = append(columns, name)
+	}
+	sort.Strings(columns)
+
+	var set, insert, values []string
+	for _, c := range columns {
+		if c != "_id" {
+			set = append(set, fmt.Sprintf("%s = S.%s", bqIdentifier(c), bqIdentifier(c)))
+		}
+		insert = append(insert, bqIdentifier(c))
+		values = append(values, "S."+bqIdentifier(c))
+	}
+	if mode == DeleteSoft {
+		set = append(set, bqIdentifier(deletedAtField)+" = NULL")
+	}
+
+	on := "T._id = S._id"
+	if f := s.PartitionFilter(); f != "" {
+		// satisfy require_partition_filter on the target table
+		on += " AND T." + f
+	}
+
+	return fmt.Sprintf("MERGE %s T USING (SELECT * FROM %s WHERE TRUE QUALIFY ROW_NUMBER() OVER (PARTITION BY _id ORDER BY _updated_at DESC) = 1) S ON %s\nWHEN MATCHED THEN UPDATE SET %s\nWHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+		target, staging, on,
+		strings.Join(set, ", "),
+		strings.Join(insert, ", "),
+		strings.Join(values, ", "))
+}
+
+// insertNewSQL builds a MERGE statement that inserts the rows from staging whose _id is
+// not already in target. Duplicate _ids within staging are inserted once.
+func insertNewSQL(target, staging string, s TableSchema) string {
+	var columns []string
+	for name := range s {
+		columns = append(columns, name)
+	}
+	sort.Strings(columns)
+
+	var insert, values []string
+	for _, c := range columns {
+		insert = append(insert, bqIdentifier(c))
+		values = append(values, "S."+bqIdentifier(c))
+	}
+
+	on := "T._id = S._id"
+	if f := s.PartitionFilter(); f != "" {
+		// satisfy require_partition_filter on the target table
+		on += " AND T." + f
+	}
+
+	return fmt.Sprintf("MERGE %s T USING (SELECT * FROM %s WHERE TRUE QUALIFY ROW_NUMBER() OVER (PARTITION BY _id) = 1) S ON %s\nWHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+		target, staging, on,
+		strings.Join(insert, ", "),
+		strings.Join(values, ", "))
+}
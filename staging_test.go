@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestMergeSQL(t *testing.T) {
+	ts := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_updated_at": {SourceField: ":updated_at", Type: bigquery.TimestampFieldType, Required: true},
+		"name":        {SourceField: "name", Type: bigquery.StringFieldType},
+	}
+	got := mergeSQL("`p.d.t`", "`p.d.t_staging`", ts, "")
+	expect := "MERGE `p.d.t` T USING (SELECT * FROM `p.d.t_staging` WHERE TRUE QUALIFY ROW_NUMBER() OVER (PARTITION BY _id ORDER BY _updated_at DESC) = 1) S ON T._id = S._id\n" +
+		"WHEN MATCHED THEN UPDATE SET `_updated_at` = S.`_updated_at`, `name` = S.`name`\n" +
+		"WHEN NOT MATCHED THEN INSERT (`_id`, `_updated_at`, `name`) VALUES (S.`_id`, S.`_updated_at`, S.`name`)"
+	if got != expect {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expect)
+	}
+}
+
+func TestMergeSQL_Partitioned(t *testing.T) {
+	ts := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_created_at": {SourceField: ":created_at", Type: bigquery.TimestampFieldType, Required: true, TimePartition: TimePartitionDay},
+		"_updated_at": {SourceField: ":updated_at", Type: bigquery.TimestampFieldType, Required: true},
+	}
+	got := mergeSQL("`p.d.t`", "`p.d.s`", ts, "")
+	expect := "MERGE `p.d.t` T USING (SELECT * FROM `p.d.s` WHERE TRUE QUALIFY ROW_NUMBER() OVER (PARTITION BY _id ORDER BY _updated_at DESC) = 1) S ON T._id = S._id AND T.`_created_at` IS NOT NULL\n" +
+		"WHEN MATCHED THEN UPDATE SET `_created_at` = S.`_created_at`, `_updated_at` = S.`_updated_at`\n" +
+		"WHEN NOT MATCHED THEN INSERT (`_created_at`, `_id`, `_updated_at`) VALUES (S.`_created_at`, S.`_id`, S.`_updated_at`)"
+	if got != expect {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expect)
+	}
+}
+
+func TestMergeSQL_SoftDelete(t *testing.T) {
+	ts := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_updated_at": {SourceField: ":updated_at", Type: bigquery.TimestampFieldType, Required: true},
+	}
+	got := mergeSQL("`p.d.t`", "`p.d.s`", ts, DeleteSoft)
+	expect := "MERGE `p.d.t` T USING (SELECT * FROM `p.d.s` WHERE TRUE QUALIFY ROW_NUMBER() OVER (PARTITION BY _id ORDER BY _updated_at DESC) = 1) S ON T._id = S._id\n" +
+		"WHEN MATCHED THEN UPDATE SET `_updated_at` = S.`_updated_at`, `_deleted_at` = NULL\n" +
+		"WHEN NOT MATCHED THEN INSERT (`_id`, `_updated_at`) VALUES (S.`_id`, S.`_updated_at`)"
+	if got != expect {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expect)
+	}
+}
+
+func TestInsertNewSQL(t *testing.T) {
+	ts := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_created_at": {SourceField: ":created_at", Type: bigquery.TimestampFieldType, Required: true, TimePartition: TimePartitionDay},
+		"name":        {SourceField: "name", Type: bigquery.StringFieldType},
+	}
+	got := insertNewSQL("`p.d.t`", "`p.d.s`", ts)
+	expect := "MERGE `p.d.t` T USING (SELECT * FROM `p.d.s` WHERE TRUE QUALIFY ROW_NUMBER() OVER (PARTITION BY _id) = 1) S ON T._id = S._id AND T.`_created_at` IS NOT NULL\n" +
+		"WHEN NOT MATCHED THEN INSERT (`_created_at`, `_id`, `name`) VALUES (S.`_created_at`, S.`_id`, S.`name`)"
+	if got != expect {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expect)
+	}
+}
+
+// a sync interrupted after a chunk is loaded but before the checkpoint is committed
+// loads that chunk into staging again when it resumes
+func TestChunkLoadedTwice(t *testing.T) {
+	ts := TableSchema{
+		"_id":         {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+		"_updated_at": {SourceField: ":updated_at", Type: bigquery.TimestampFieldType, Required: true},
+		"name":        {SourceField: "name", Type: bigquery.StringFieldType},
+	}
+	// MERGE fails when more than one source row matches a target row
+	if got := mergeSQL("`p.d.t`", "`p.d.s`", ts, ""); !strings.Contains(got, "USING (SELECT * FROM `p.d.s` WHERE TRUE QUALIFY ROW_NUMBER() OVER (PARTITION BY _id ORDER BY _updated_at DESC) = 1) S") {
+		t.Errorf("MERGE source is not deduplicated\n%s", got)
+	}
+	// REPLACE copies staging as is so it is deduplicated first
+	expect := "BEGIN TRANSACTION;\n" +
+		"CREATE TEMP TABLE deduped AS SELECT row.* FROM (SELECT ARRAY_AGG(t ORDER BY t._updated_at DESC LIMIT 1)[OFFSET(0)] AS row FROM `p.d.s` t GROUP BY t._id HAVING COUNT(*) > 1);\n" +
+		"DELETE FROM `p.d.s` WHERE _id IN (SELECT _id FROM deduped);\n" +
+		"INSERT INTO `p.d.s` SELECT * FROM deduped;\n" +
+		"COMMIT TRANSACTION;"
+	if got := dedupeSQL("`p.d.s`", ts); got != expect {
+		t.Errorf("got\n%s\nexpected\n%s", got, expect)
+	}
+	// APPEND inserts each _id once
+	if got := insertNewSQL("`p.d.t`", "`p.d.s`", ts); !strings.Contains(got, "QUALIFY ROW_NUMBER() OVER (PARTITION BY _id) = 1") {
+		t.Errorf("APPEND source is not deduplicated\n%s", got)
+	}
+	// every mode requires _id to find the duplicate rows
+	for _, mode := range []SyncMode{SyncAppend, SyncMerge, SyncReplace} {
+		cf := ConfigFile{Config: Config{CheckpointRows: 1000, BigQuery: BigQuery{SyncMode: mode}}, Schema: TableSchema{"name": ts["name"]}, filename: "config.toml"}
+		var found bool
+		for _, e := range cf.Validate() {
+			if e.Error() == `config.toml: CheckpointRows: requires schema field "_id"` {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected CheckpointRows to require _id with SyncMode %s", mode)
+		}
+	}
+}
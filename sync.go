@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/bigquery"
@@ -22,7 +21,9 @@ func bqIdentifier(name string) string {
 	return "`" + strings.ReplaceAll(name, "`", "") + "`"
 }
 
-func syncOne(configFile string, quiet bool, token string) {
+// syncOne syncs a single config file. When verify is set the BigQuery table is then
+// compared with Socrata (see verifyTable) and the result is returned.
+func syncOne(configFile string, quiet bool, token string, fullRefresh, verify bool) bool {
 	cf, err := LoadConfigFile(configFile)
 	if err != nil {
 		log.Fatal(err)
@@ -86,63 +87,331 @@ func syncOne(configFile string, quiet bool, token string) {
 	}
 	fmt.Printf("BQ Table %s OK (last modified %s)\n", tmd.FullID, tmd.LastModifiedTime)
 
+	tmd, err = evolveSchema(ctx, &cf, *md, bqTable, tmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cf.BigQuery.DeleteMode == DeleteSoft {
+		// deletes are marked in _deleted_at and a MERGE clears it for restored records
+		tmd, err = ensureDeletedAtColumn(ctx, bqTable, tmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	fmt.Printf("BQ Records: %d\n", tmd.NumRows)
 	if socrataCount == 0 {
-		return
+		return true
 	}
-	missing := socrataCount - int64(tmd.NumRows)
-	if missing <= 0 {
-		fmt.Printf("0 out-of-sync records found\n")
-		fmt.Printf("Sync Complete\n")
-		return
+	mode := cf.BigQuery.Mode()
+	if fullRefresh {
+		mode = SyncReplace
 	}
-
-	where := cf.BigQuery.WhereFilter
-	if tmd.NumRows > 0 {
-		// automatically generate a where clause picking up after the last incremental cursor value
-		q := bqclient.Query(fmt.Sprintf("SELECT max(_created_at) as created FROM %s %s", cf.BigQuery.SQLTableName(), cf.Schema.PartitionWhereClause()))
-		it, err := q.Read(ctx)
+	bqCount := int64(tmd.NumRows)
+	if cf.BigQuery.DeleteMode != "" && tmd.NumRows > 0 && mode != SyncReplace {
+		if err := reconcileDeletes(ctx, bqclient, dataset, cf, datasetID, token, bqTable); err != nil {
+			log.Fatal(err)
+		}
+		bqCount, err = countRows(ctx, bqclient, cf)
 		if err != nil {
 			log.Fatal(err)
 		}
-		type Result struct {
-			Created time.Time
+		fmt.Printf("BQ Records: %d\n", bqCount)
+	}
+	missing := socrataCount - bqCount
+	if mode == SyncAppend {
+		if missing == 0 {
+			fmt.Printf("0 out-of-sync records found\n")
+			fmt.Printf("Sync Complete\n")
+			return !verify || verifySync(ctx, bqclient, cf, token)
+		}
+		if missing < 0 {
+			// new records may still be offset by deleted ones, so continue with the incremental sync
+			fmt.Printf("WARNING: %d more records in BigQuery than Socrata. Records were deleted or republished in Socrata; see DeleteMode or sync -full-refresh\n", -missing)
 		}
-		var r Result
-		for {
-			err := it.Next(&r)
-			if err == iterator.Done {
-				break
+	}
+
+	// with an _id field APPEND stages records and inserts only new _ids, so overlapping
+	// or repeated loads don't duplicate rows
+	_, hasID := cf.Schema["_id"]
+	insertNew := mode == SyncAppend && hasID
+
+	// automatically generate a where clause picking up after the last incremental cursor value
+	var cursor string
+	switch mode {
+	case SyncAppend:
+		if tmd.NumRows > 0 {
+			created, err := maxTimestamp(ctx, bqclient, cf, "_created_at")
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !created.IsZero() {
+				fmt.Printf("BigQuery most recent record created_at: %s\n", created)
+				if insertNew {
+					// records created in the same second as the cursor are re-read and skipped by _id
+					cursor = fmt.Sprintf(":created_at >= '%s'", created.Format(time.RFC3339))
+				} else {
+					cursor = fmt.Sprintf(":created_at >= '%s'", created.Add(time.Second).Format(time.RFC3339))
+				}
 			}
-			if err != nil && err.Error() == "bigquery: NULL values cannot be read into structs" {
-				break
+		}
+	case SyncMerge:
+		for _, f := range []string{"_id", "_updated_at"} {
+			if _, ok := cf.Schema[f]; !ok {
+				log.Fatalf("SyncMode %s requires schema field %q", mode, f)
 			}
+		}
+		if tmd.NumRows > 0 {
+			updated, err := maxTimestamp(ctx, bqclient, cf, "_updated_at")
 			if err != nil {
 				log.Fatal(err)
 			}
-		}
-		if !r.Created.IsZero() {
-			fmt.Printf("BigQuery most recent record created_at: %s\n", r.Created)
-			createdFilter := fmt.Sprintf(":created_at >= '%s'", r.Created.Add(time.Second).Format(time.RFC3339))
-			fmt.Printf("> filtering to %s\n", createdFilter)
-			if where == "" {
-				where = createdFilter
-			} else {
-				where = where + " AND " + createdFilter
+			if !updated.IsZero() {
+				// records updated in the same second as the cursor are re-applied; MERGE makes that harmless
+				fmt.Printf("BigQuery most recent record updated_at: %s\n", updated)
+				cursor = fmt.Sprintf(":updated_at >= '%s'", updated.Format(time.RFC3339))
 			}
 		}
+	case SyncReplace:
+		// the full dataset is reloaded
+	default:
+		log.Fatalf("unknown SyncMode %q", mode)
+	}
+
+	where := cf.BigQuery.WhereFilter
+	if cursor != "" {
+		fmt.Printf("> filtering to %s\n", cursor)
+		where = andWhere(where, cursor)
 	}
 
-	client, err := storage.NewClient(ctx)
+	var client *storage.Client
+	if cf.StagingMethod() == StagingGCS || strings.HasPrefix(cf.CheckpointPath(), "gs://") || strings.HasPrefix(cf.Rejects, "gs://") {
+		client, err = storage.NewClient(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	st, err := newStager(cf, client)
 	if err != nil {
 		log.Fatal(err)
 	}
-	bkt := client.Bucket(cf.GoogleStorageBucketName)
+	rejects, err := newRejectSink(ctx, cf, client, st, dataset)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var cp *checkpointer
+	var staging *bigquery.Table
+	if cf.CheckpointRows > 0 {
+		if !hasID {
+			// a chunk loaded again when a sync resumes is found by _id; APPEND then stages
+			// records and inserts only new _ids instead of loading into the table directly
+			log.Fatalf("CheckpointRows requires schema field %q", "_id")
+		}
+		store := newCheckpointStore(client, cf.CheckpointPath())
+		state, err := store.Load(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if state != nil && state.DatasetID == datasetID && state.Mode == mode {
+			t := dataset.Table(state.Table)
+			if _, err := t.Metadata(ctx); err != nil {
+				fmt.Printf("Discarding checkpoint %s: table %s %s\n", store.path, state.Table, err)
+				state = nil
+			} else {
+				fmt.Printf("Resuming from checkpoint %s: %d rows loaded through %s\n", store.path, state.Rows, state.Cursor)
+				where = state.Where
+				missing -= state.Rows
+				if mode != SyncAppend || insertNew {
+					staging = t
+				}
+			}
+		} else {
+			state = nil
+		}
+		cp = &checkpointer{store: store}
+		if state != nil {
+			cp.state = *state
+		} else {
+			cp.state = Checkpoint{DatasetID: datasetID, Mode: mode, Table: bqTable.TableID, Where: where}
+		}
+	}
+
+	if (mode != SyncAppend || insertNew) && staging == nil {
+		// copying into the target requires the staging table be partitioned the same way
+		// and keep the _deleted_at column of DeleteMode SOFT
+		schema := cf.Schema.BigQuerySchema()
+		if cf.BigQuery.DeleteMode == DeleteSoft {
+			schema = append(schema, deletedAtSchema)
+		}
+		staging, err = createStagingTable(ctx, dataset, stagingTableName(cf, "staging"), schema, timePartitioning)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cp != nil {
+			cp.state.Table = staging.TableID
+		}
+	}
 
-	if err := streamAndLoad(ctx, cf, datasetID, where, token, bkt, bqTable, quiet, missing); err != nil {
+	switch mode {
+	case SyncAppend:
+		if insertNew {
+			err = appendLoad(ctx, bqclient, cf, datasetID, where, token, st, rejects, staging, bqTable, quiet, missing, cp)
+		} else {
+			err = streamAndLoad(ctx, cf, datasetID, where, token, st, rejects, bqTable, quiet, missing, cp)
+		}
+	case SyncMerge:
+		var changed int64
+		changed, err = CountV3(ctx, apiBase, datasetID, andWhere(where, cp.Cursor().Where()), token)
+		if err != nil {
+			break
+		}
+		fmt.Printf("Socrata Records created or updated: %d\n", changed)
+		err = mergeLoad(ctx, bqclient, cf, datasetID, where, token, st, rejects, staging, bqTable, quiet, changed, cp)
+	case SyncReplace:
+		err = replaceLoad(ctx, bqclient, cf, datasetID, where, token, st, rejects, staging, bqTable, quiet, missing, cp)
+	}
+	if rejects != nil {
+		if rerr := rejects.Close(ctx); err == nil {
+			err = rerr
+		}
+	}
+	if err != nil {
+		if staging != nil && cp == nil {
+			dropStagingTable(ctx, staging)
+		}
 		log.Fatal(err)
 	}
+	if staging != nil {
+		dropStagingTable(ctx, staging)
+	}
+	if cp != nil {
+		if err := cp.store.Remove(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}
 	fmt.Printf("Sync Complete\n")
+	return !verify || verifySync(ctx, bqclient, cf, token)
+}
+
+// maxTimestamp returns the most recent value of a TIMESTAMP column in the BigQuery table,
+// or the zero time if the table has no values
+func maxTimestamp(ctx context.Context, bqclient *bigquery.Client, cf ConfigFile, column string) (time.Time, error) {
+	q := bqclient.Query(fmt.Sprintf("SELECT max(%s) as value FROM %s %s", bqIdentifier(column), cf.BigQuery.SQLTableName(), cf.Schema.PartitionWhereClause()))
+	it, err := q.Read(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	type Result struct {
+		Value time.Time
+	}
+	var r Result
+	for {
+		err := it.Next(&r)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil && err.Error() == "bigquery: NULL values cannot be read into structs" {
+			break
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return r.Value, nil
+}
+
+// liveRowsFilter returns the conditions which exclude rows marked deleted by DeleteMode SOFT
+// (and satisfy require_partition_filter)
+func liveRowsFilter(cf ConfigFile) []string {
+	var where []string
+	if f := cf.Schema.PartitionFilter(); f != "" {
+		where = append(where, f)
+	}
+	if cf.BigQuery.DeleteMode == DeleteSoft {
+		where = append(where, deletedAtField+" IS NULL")
+	}
+	return where
+}
+
+// countRows returns the number of rows in the BigQuery table, excluding rows marked deleted by DeleteMode SOFT
+func countRows(ctx context.Context, bqclient *bigquery.Client, cf ConfigFile) (int64, error) {
+	where := liveRowsFilter(cf)
+	sql := "SELECT COUNT(*) as value FROM " + cf.BigQuery.SQLTableName()
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	it, err := bqclient.Query(sql).Read(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var r struct {
+		Value int64
+	}
+	if err := it.Next(&r); err != nil {
+		return 0, err
+	}
+	return r.Value, nil
+}
+
+// mergeLoad stages matching records in the staging table and MERGEs them into bqTable on _id
+func mergeLoad(ctx context.Context, bqclient *bigquery.Client, cf ConfigFile, datasetID, where, token string, st stager, rejects *rejectSink, staging, bqTable *bigquery.Table, quiet bool, missing int64, cp *checkpointer) error {
+	if err := streamAndLoad(ctx, cf, datasetID, where, token, st, rejects, staging, quiet, missing, cp); err != nil {
+		return err
+	}
+
+	status, err := runQuery(ctx, bqclient, mergeSQL(sqlTable(bqTable), sqlTable(staging), cf.Schema, cf.BigQuery.DeleteMode))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged %d rows into %s\n", affectedRows(status), bqTable.TableID)
+	return nil
+}
+
+// appendLoad stages matching records in the staging table and inserts those with an
+// _id not already in bqTable
+func appendLoad(ctx context.Context, bqclient *bigquery.Client, cf ConfigFile, datasetID, where, token string, st stager, rejects *rejectSink, staging, bqTable *bigquery.Table, quiet bool, missing int64, cp *checkpointer) error {
+	if err := streamAndLoad(ctx, cf, datasetID, where, token, st, rejects, staging, quiet, missing, cp); err != nil {
+		return err
+	}
+
+	status, err := runQuery(ctx, bqclient, insertNewSQL(sqlTable(bqTable), sqlTable(staging), cf.Schema))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Inserted %d new rows into %s\n", affectedRows(status), bqTable.TableID)
+	return nil
+}
+
+// replaceLoad loads the full dataset into the staging table and then atomically
+// replaces the contents of bqTable with it using a table copy
+func replaceLoad(ctx context.Context, bqclient *bigquery.Client, cf ConfigFile, datasetID, where, token string, st stager, rejects *rejectSink, staging, bqTable *bigquery.Table, quiet bool, missing int64, cp *checkpointer) error {
+	if err := streamAndLoad(ctx, cf, datasetID, where, token, st, rejects, staging, quiet, missing, cp); err != nil {
+		return err
+	}
+	if cp != nil {
+		// a resumed sync loads the chunk interrupted before its checkpoint twice
+		if _, err := runQuery(ctx, bqclient, dedupeSQL(sqlTable(staging), cf.Schema)); err != nil {
+			return err
+		}
+	}
+
+	copier := bqTable.CopierFrom(staging)
+	copier.WriteDisposition = bigquery.WriteTruncate
+	job, err := copier.Run(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("BigQuery copy running job %s\n", job.ID())
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if err := status.Err(); err != nil {
+		return err
+	}
+	fmt.Printf("Replaced %s with %s\n", bqTable.TableID, staging.TableID)
+	return nil
 }
 
 // estimate calculates the remaining rows and estimated time remaining based on the
@@ -157,39 +426,133 @@ func estimate(count, missing int64, elapsed time.Duration) (int64, time.Duration
 	return remainingRows, 0
 }
 
-func streamAndLoad(ctx context.Context, cf ConfigFile, datasetID, where, token string, bkt *storage.BucketHandle, bqTable *bigquery.Table, quiet bool, missing int64) error {
-	sql := "SELECT :*, *"
-	if where != "" {
-		sql += " WHERE " + where
-	}
+// streamAndLoad streams records matching where from Socrata, transforms them and
+// loads them into bqTable. When cp is set the records are loaded in chunks of
+// cf.CheckpointRows and progress is committed to the checkpoint after each load.
+// Rows with values skipped by on_error are written to rejects when it is set.
+func streamAndLoad(ctx context.Context, cf ConfigFile, datasetID, where, token string, st stager, rejects *rejectSink, bqTable *bigquery.Table, quiet bool, missing int64, cp *checkpointer) error {
+	// records are streamed in a stable order so the checkpoint cursor identifies every
+	// record that has been loaded and a failed response resumes after the last record
+	order := cf.Order()
 
-	obj := bkt.Object(filepath.Join("socrata_to_bigquery", time.Now().Format("20060102-150405"), datasetID+".json.gz"))
-	fmt.Printf("> writing to %s/%s\n", cf.GSBucket(), obj.ObjectName())
-	w := obj.NewWriter(ctx)
-	w.ContentType = "application/json"
-	w.ContentEncoding = "gzip"
-	bw := bufio.NewWriterSize(w, 5*1024*1024) // 5MB buffer
-	gw := gzip.NewWriter(bw)
-	enc := json.NewEncoder(gw)
-	enc.SetEscapeHTML(false)
+	prefix := filepath.Join("socrata_to_bigquery", time.Now().Format("20060102-150405"))
+	chunkName := func(n int) string {
+		if cp == nil {
+			return filepath.Join(prefix, datasetID+cf.Format().Ext())
+		}
+		return filepath.Join(prefix, fmt.Sprintf("%s-%05d%s", datasetID, n, cf.Format().Ext()))
+	}
 
 	var rows int64
+	var stats transformStats
+	var statsMu sync.Mutex // stats are updated while streaming and checked before each load
+	var streamErr error
 	start := time.Now()
-	out := make(chan Record, 100000)
+	out := make(chan stagedRow, 100000)
+	loads := make(chan *chunk, 1)
 	wg, ctxg := errgroup.WithContext(ctx)
-	// gorotine for encoding and writing to GCS
+	// goroutine for encoding and writing chunks to the staged files
 	wg.Go(func() error {
+		defer close(loads)
+		var n int
+		var c *chunk
 		for row := range out {
-			if err := enc.Encode(row); err != nil {
+			if c == nil {
+				n++
+				f, err := st.Create(ctxg, chunkName(n), cf.Format())
+				if err != nil {
+					return err
+				}
+				fmt.Printf("> writing to %s\n", f)
+				if c, err = newChunk(f, cf.Format(), cf.Schema); err != nil {
+					_ = f.Close()
+					return err
+				}
+			}
+			if err := c.Write(row); err != nil {
 				return err
 			}
+			if cp != nil && c.rows >= cf.CheckpointRows {
+				if err := c.Close(); err != nil {
+					return err
+				}
+				statsMu.Lock()
+				err := checkErrorBudgets(cf, &stats, false)
+				statsMu.Unlock()
+				if err != nil {
+					_ = c.file.Remove(ctxg)
+					return err
+				}
+				select {
+				case loads <- c:
+				case <-ctxg.Done():
+					return ctxg.Err()
+				}
+				c = nil
+			}
+		}
+		if c == nil {
+			return nil
+		}
+		if err := c.Close(); err != nil {
+			return err
+		}
+		if streamErr != nil {
+			// the final chunk is incomplete; leave it for the next sync
+			return c.file.Remove(ctxg)
+		}
+		statsMu.Lock()
+		err := checkErrorBudgets(cf, &stats, false)
+		statsMu.Unlock()
+		if err != nil {
+			_ = c.file.Remove(ctxg)
+			return err
+		}
+		select {
+		case loads <- c:
+		case <-ctxg.Done():
+			return ctxg.Err()
 		}
 		return nil
 	})
-	streamErr := StreamV3(ctxg, cf.APIBase(), datasetID, sql, token, func(row Record) error {
-		mm, err := TransformOne(row, cf.Schema)
+	// goroutine for loading completed chunks into BigQuery in order
+	wg.Go(func() error {
+		for c := range loads {
+			if err := c.Load(ctxg, bqTable); err != nil {
+				return err
+			}
+			if cp != nil {
+				if err := cp.Commit(ctxg, c.rows, c.cursor); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	handle := func(row Record) error {
+		mm, errs, err := TransformRecord(row, cf.Schema)
+		statsMu.Lock()
+		stats.Add(row, mm, errs, err)
+		var budgetErr error
+		if len(errs) > 0 {
+			// count budgets can only grow so stop streaming as soon as one is exceeded
+			budgetErr = checkErrorBudgets(cf, &stats, true)
+		}
+		statsMu.Unlock()
+		if budgetErr != nil {
+			return budgetErr
+		}
+		if rejects == nil {
+			logFieldErrors(errs)
+		} else if len(errs) > 0 && err == nil {
+			// rows loaded with SKIP_VALUE values set to null are written too; each
+			// error is marked with its on_error
+			if err := rejects.Write(row, errs); err != nil {
+				return err
+			}
+		}
 		if err != nil {
-			return fmt.Errorf("row %d: %w", rows+1, err)
+			return fmt.Errorf("row %d: %w", stats.Rows, err)
 		}
 		if mm != nil {
 			rows++
@@ -202,25 +565,24 @@ func streamAndLoad(ctx context.Context, cf ConfigFile, datasetID, where, token s
 					log.Printf("processed %d rows (%s)", rows, elapsed.Truncate(time.Second))
 				}
 			}
-			out <- mm
+			select {
+			case out <- stagedRow{record: mm, cursor: order.Cursor(row)}:
+			case <-ctxg.Done():
+				return ctxg.Err()
+			}
 		}
 		return nil
-	})
+	}
+	streamErr = StreamPagedV3(ctxg, cf.APIBase(), datasetID, "SELECT :*, *", where, token, order, cf.PageSize, cp.Cursor(), handle)
 	if streamErr != nil {
 		log.Printf("streamErr: %s", streamErr)
 	}
 
 	close(out)
-	if err := wg.Wait(); err != nil {
-		return err
+	if len(stats.Fields) > 0 {
+		stats.Print(os.Stdout)
 	}
-	if err := gw.Close(); err != nil {
-		return err
-	}
-	if err := bw.Flush(); err != nil {
-		return err
-	}
-	if err := w.Close(); err != nil {
+	if err := wg.Wait(); err != nil {
 		return err
 	}
 	if streamErr != nil {
@@ -229,35 +591,6 @@ func streamAndLoad(ctx context.Context, cf ConfigFile, datasetID, where, token s
 
 	if rows == 0 {
 		fmt.Printf("0 out-of-sync records found\n")
-		if err := obj.Delete(ctx); err != nil {
-			return err
-		}
-		return nil
-	}
-
-	fmt.Printf("Queued %d rows for BigQuery load\n", rows)
-	gcsRef := bigquery.NewGCSReference(fmt.Sprintf("%s/%s", cf.GSBucket(), obj.ObjectName()))
-	gcsRef.SourceFormat = bigquery.JSON
-
-	loader := bqTable.LoaderFrom(gcsRef)
-	loader.WriteDisposition = bigquery.WriteAppend
-
-	loadJob, err := loader.Run(ctx)
-	if err != nil {
-		return err
-	}
-	fmt.Printf("BigQuery import running job %s\n", loadJob.ID())
-	status, err := loadJob.Wait(ctx)
-	fmt.Printf("BigQuery import job %s done\n", loadJob.ID())
-	if err != nil {
-		return err
-	}
-	if err = status.Err(); err != nil {
-		return err
-	}
-
-	if err = obj.Delete(ctx); err != nil {
-		return err
 	}
 	return nil
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// TimeFormats are time.Parse layouts tried in order. In a config file time_format
+// may be a single layout or a list.
+type TimeFormats []string
+
+// UnmarshalTOML accepts a single layout string or a list of layout strings
+func (f *TimeFormats) UnmarshalTOML(v interface{}) error {
+	switch s := v.(type) {
+	case string:
+		if s == "" {
+			*f = nil
+		} else {
+			*f = TimeFormats{s}
+		}
+		return nil
+	case []interface{}:
+		formats := make(TimeFormats, 0, len(s))
+		for _, e := range s {
+			format, ok := e.(string)
+			if !ok {
+				return fmt.Errorf("time_format must be a string or a list of strings (got %T in list)", e)
+			}
+			formats = append(formats, format)
+		}
+		*f = formats
+		return nil
+	}
+	return fmt.Errorf("time_format must be a string or a list of strings (got %T)", v)
+}
+
+// Convert returns convert(format, s) for the first format that parses s, or the error
+// from the first format when none do. With no formats convert is called with "".
+func (f TimeFormats) Convert(s string, convert func(format, s string) (interface{}, error)) (interface{}, error) {
+	if len(f) == 0 {
+		return convert("", s)
+	}
+	var firstErr error
+	for _, format := range f {
+		v, err := convert(format, s)
+		if err == nil {
+			return v, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// Common layouts of dates and times stored in Socrata text columns. Month first dates
+// are assumed (i.e. 01/02/2006 is January 2).
+var (
+	dateLayouts = []string{
+		"01/02/2006",
+		"1/2/2006",
+		"2006-01-02",
+		"2006/01/02",
+		"01-02-2006",
+		"Jan 2, 2006",
+		"January 2, 2006",
+		"02-Jan-2006",
+	}
+	dateTimeLayouts = []string{
+		"01/02/2006 03:04:05 PM",
+		"1/2/2006 3:04:05 PM",
+		"01/02/2006 03:04 PM",
+		"1/2/2006 3:04 PM",
+		"01/02/2006 15:04:05",
+		"01/02/2006 15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006/01/02 15:04:05",
+	}
+	// layouts with a UTC offset, detected as TIMESTAMP
+	timestampLayouts = []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05Z07:00",
+		"2006-01-02 15:04:05 -0700",
+		"2006-01-02T15:04:05-0700",
+		"01/02/2006 03:04:05 PM -0700",
+	}
+	// ToTime matches case-insensitively
+	timeLayouts = []string{
+		"15:04",
+		"15:04:05",
+		"03:04 pm",
+		"3:04 pm",
+		"03:04pm",
+		"3:04pm",
+		"03:04:05 pm",
+		"0304p",
+	}
+)
+
+// detectTimeFormats finds the BigQuery type and the shortest list of common layouts
+// which parse every non-empty example value. ok is false when the values are not
+// all dates, datetimes, timestamps or times.
+func detectTimeFormats(values []string) (t bigquery.FieldType, formats TimeFormats, ok bool) {
+	var nonEmpty []string
+	for _, v := range values {
+		if v != "" {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return "", nil, false
+	}
+	toDateTime := func(format, s string) (interface{}, error) {
+		return ToDateTime(format, time.UTC, s)
+	}
+	if formats, ok := coverValues(nonEmpty, dateLayouts, ToDate); ok {
+		return bigquery.DateFieldType, formats, true
+	}
+	// a column mixing dates and datetimes is loaded as DATETIME (dates at midnight)
+	if formats, ok := coverValues(nonEmpty, append(append([]string{}, dateTimeLayouts...), dateLayouts...), toDateTime); ok {
+		return bigquery.DateTimeFieldType, formats, true
+	}
+	toTimestamp := func(format, s string) (interface{}, error) {
+		return ToTimestamp(format, time.UTC, s)
+	}
+	if formats, ok := coverValues(nonEmpty, timestampLayouts, toTimestamp); ok {
+		return bigquery.TimestampFieldType, formats, true
+	}
+	if formats, ok := coverValues(nonEmpty, timeLayouts, ToTime); ok {
+		return bigquery.TimeFieldType, formats, true
+	}
+	return "", nil, false
+}
+
+// coverValues greedily picks the layout that parses the most values not yet parsed
+// until every value is parsed. Ties are broken by the order of layouts.
+func coverValues(values, layouts []string, convert func(format, s string) (interface{}, error)) (TimeFormats, bool) {
+	parses := make(map[string][]int)
+	for _, layout := range layouts {
+		for i, v := range values {
+			if _, err := convert(layout, v); err == nil {
+				parses[layout] = append(parses[layout], i)
+			}
+		}
+	}
+	parsed := make(map[int]bool)
+	var formats TimeFormats
+	for len(parsed) < len(values) {
+		best, bestCount := "", 0
+		for _, layout := range layouts {
+			var n int
+			for _, i := range parses[layout] {
+				if !parsed[i] {
+					n++
+				}
+			}
+			if n > bestCount {
+				best, bestCount = layout, n
+			}
+		}
+		if bestCount == 0 {
+			return nil, false
+		}
+		formats = append(formats, best)
+		for _, i := range parses[best] {
+			parsed[i] = true
+		}
+	}
+	return formats, true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pelletier/go-toml"
+)
+
+func TestTimeFormatsTOML(t *testing.T) {
+	type testCase struct {
+		in       string
+		expected TimeFormats
+	}
+	tests := []testCase{
+		{`time_format = "01/02/2006"`, TimeFormats{"01/02/2006"}},
+		{`time_format = ["01/02/2006", "2006-01-02"]`, TimeFormats{"01/02/2006", "2006-01-02"}},
+		{`time_format = ""`, nil},
+		{``, nil},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var f SchemaField
+			if err := toml.Unmarshal([]byte(tc.in), &f); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(f.TimeFormat, tc.expected) {
+				t.Errorf("got %#v expected %#v", f.TimeFormat, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTimeFormatsUnmarshalTOML(t *testing.T) {
+	type testCase struct {
+		in       interface{}
+		expected TimeFormats
+		err      bool
+	}
+	tests := []testCase{
+		{"01/02/2006", TimeFormats{"01/02/2006"}, false},
+		{[]interface{}{"01/02/2006", "2006-01-02"}, TimeFormats{"01/02/2006", "2006-01-02"}, false},
+		{[]interface{}{}, TimeFormats{}, false},
+		{[]interface{}{"01/02/2006", int64(1)}, nil, true},
+		{int64(1), nil, true},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var f TimeFormats
+			err := f.UnmarshalTOML(tc.in)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v", err)
+			}
+			if !reflect.DeepEqual(f, tc.expected) {
+				t.Errorf("got %#v expected %#v", f, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTimeFormatsConvert(t *testing.T) {
+	formats := TimeFormats{"01/02/2006", "2006-01-02", "Jan 2, 2006"}
+	type testCase struct {
+		in       string
+		expected interface{}
+		err      bool
+	}
+	tests := []testCase{
+		{"03/06/2017", "2017-03-06", false},
+		{"2017-03-06", "2017-03-06", false},
+		{"Mar 6, 2017", "2017-03-06", false},
+		{"", nil, false},
+		{"6 March 2017", nil, true},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := formats.Convert(tc.in, ToDate)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("got %#v expected %#v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDetectTimeFormats(t *testing.T) {
+	type testCase struct {
+		values  []string
+		t       bigquery.FieldType
+		formats TimeFormats
+		ok      bool
+	}
+	tests := []testCase{
+		{[]string{"03/06/2017", "10/07/2017", ""}, bigquery.DateFieldType, TimeFormats{"01/02/2006"}, true},
+		{[]string{"03/06/2017", "2017-10-07", "10/17/2017"}, bigquery.DateFieldType, TimeFormats{"01/02/2006", "2006-01-02"}, true},
+		{[]string{"3/6/2017", "10/17/2017"}, bigquery.DateFieldType, TimeFormats{"1/2/2006"}, true},
+		{[]string{"03/06/2017 10:15:00 PM", "03/07/2017"}, bigquery.DateTimeFieldType, TimeFormats{"01/02/2006 03:04:05 PM", "01/02/2006"}, true},
+		{[]string{"2017-03-06T10:15:00"}, bigquery.DateTimeFieldType, TimeFormats{"2006-01-02T15:04:05"}, true},
+		{[]string{"2017-03-06T10:15:00Z", "2017-03-06T10:15:00.123-05:00"}, bigquery.TimestampFieldType, TimeFormats{time.RFC3339}, true},
+		{[]string{"10:15 PM", "09:00 AM"}, bigquery.TimeFieldType, TimeFormats{"03:04 pm"}, true},
+		{[]string{"22:15", "09:00"}, bigquery.TimeFieldType, TimeFormats{"15:04"}, true},
+		{[]string{"03/06/2017", "not a date"}, "", nil, false},
+		{[]string{"1234"}, "", nil, false},
+		{[]string{""}, "", nil, false},
+		{nil, "", nil, false},
+	}
+	for i, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, formats, ok := detectTimeFormats(tc.values)
+			if ok != tc.ok || got != tc.t || !reflect.DeepEqual(formats, tc.formats) {
+				t.Errorf("got %v %#v %v expected %v %#v %v", got, formats, ok, tc.t, tc.formats, tc.ok)
+			}
+		})
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata" // time zones for the timezone setting on systems without zoneinfo
 
 	"cloud.google.com/go/bigquery"
 )
@@ -64,57 +66,107 @@ func Transform(w io.Writer, r io.Reader, s TableSchema, quiet bool, estRows uint
 	return rows, nil
 }
 
-func truncateWithPrecision(s string, p int) string {
-	if dotIndex := strings.Index(s, "."); dotIndex != -1 {
-		precision := len(s) - dotIndex - 1
-		if precision > p {
-			return s[:len(s)-(precision-p)]
-		}
-		return s
-	}
-	return s
+// FieldError is a source value which could not be converted for a schema field
+type FieldError struct {
+	Field       string
+	SourceField string
+	Value       interface{}
+	OnError     OnError
+	Err         error
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("invalid value %q in field %q %s", e.Value, e.SourceField, e.Err)
 }
 
-// func roundToPrecision(value float64, precision int) float64 {
-// 	// Multiply by 10^precision, round, and then divide by 10^precision
-// 	factor := math.Pow(10, float64(precision))
-// 	return math.Round(value*factor) / factor
-// }
+// Policy returns the effective on_error policy
+func (e FieldError) Policy() OnError {
+	if e.OnError == "" {
+		return SkipRow
+	}
+	return e.OnError
+}
 
+// TransformOne converts a Socrata record to a record for the target schema, logging
+// invalid values. It returns a nil Record when the row is skipped.
 func TransformOne(m Record, s TableSchema) (Record, error) {
+	out, errs, err := TransformRecord(m, s)
+	logFieldErrors(errs)
+	return out, err
+}
+
+func logFieldErrors(errs []FieldError) {
+	for _, fe := range errs {
+		switch fe.Policy() {
+		case SkipValue:
+			log.Printf("skipping %s", fe)
+		case SkipRow:
+			log.Printf("skipping row. %s", fe)
+		}
+	}
+}
+
+// TransformRecord converts a Socrata record to a record for the target schema without
+// logging. Every invalid value is returned in errs and handled according to the field's
+// on_error policy: SKIP_VALUE values are set to null, SKIP_ROW returns a nil Record and
+// ERROR returns the error. Computed fields are evaluated after the other fields are converted.
+func TransformRecord(m Record, s TableSchema) (Record, []FieldError, error) {
 	out := make(Record, len(m))
+	var errs []FieldError
+	var skipRow bool
+	// fail applies the field's on_error policy and reports whether to return the error
+	fail := func(fe FieldError) bool {
+		errs = append(errs, fe)
+		switch fe.Policy() {
+		case SkipValue:
+			out[fe.Field] = nil
+		case SkipRow:
+			// keep converting so every invalid value in the row is reported
+			skipRow = true
+		case RaiseError:
+			return true
+		}
+		return false
+	}
 	for fieldName, schema := range s {
+		if schema.Computed != nil {
+			continue
+		}
 		sourceValue := m[schema.SourceField]
-		var err error
+		var err, normalizeErr error
+		if v, ok := sourceValue.(string); ok && schema.normalizes() {
+			if sourceValue, normalizeErr = schema.Normalize(v); normalizeErr != nil {
+				sourceValue = nil
+			}
+		}
 		switch schema.Type {
-		case bigquery.NumericFieldType, bigquery.FloatFieldType:
-			switch schema.SourceFieldType {
-			case "text":
-				if sourceValue != nil {
-					out[fieldName], err = strconv.ParseFloat(strings.ReplaceAll(sourceValue.(string), ",", ""), 64)
-				}
-			case "number":
-				if sourceValue != nil {
-					out[fieldName] = truncateWithPrecision(sourceValue.(string), 9)
-				} else {
-					out[fieldName] = sourceValue
-				}
-			default:
-				out[fieldName] = sourceValue
+		case bigquery.NumericFieldType, bigquery.BigNumericFieldType, bigquery.FloatFieldType, bigquery.IntegerFieldType:
+			var v string
+			v, err = numberString(sourceValue)
+			out[fieldName] = nil
+			if err == nil && v != "" {
+				out[fieldName], err = schema.ConvertNumber(strings.ReplaceAll(v, ",", ""))
+			} else if err == nil && schema.Required {
+				err = fmt.Errorf("missing required field %q", fieldName)
 			}
 		case bigquery.StringFieldType:
 			switch schema.SourceFieldType {
 			case "url":
-				out[fieldName] = sourceValue.(map[string]interface{})["url"]
-			case "text", "":
+				out[fieldName], err = objectString(sourceValue, "url")
+			case "phone":
+				out[fieldName], err = objectString(sourceValue, "phone_number")
+			case "document":
+				// keep the file id, filename and content type together
+				out[fieldName], err = objectString(sourceValue, "")
+			case "text", "", "email", "photo", "html", "blob":
 				out[fieldName] = sourceValue
-				if schema.Required {
-					if sv, ok := sourceValue.(string); ok && sv == "" || sourceValue == nil {
-						err = fmt.Errorf("missing required field %q", fieldName)
-					}
-				}
 			default:
-				return nil, fmt.Errorf("unhandled conversion from %q to %q for field %s", schema.SourceFieldType, schema.Type, fieldName)
+				return nil, errs, fmt.Errorf("unhandled conversion from %q to %q for field %s", schema.SourceFieldType, schema.Type, fieldName)
+			}
+			if schema.Required && err == nil {
+				if sv, ok := out[fieldName].(string); ok && sv == "" || out[fieldName] == nil {
+					err = fmt.Errorf("missing required field %q", fieldName)
+				}
 			}
 
 		case bigquery.GeographyFieldType:
@@ -123,13 +175,15 @@ func TransformOne(m Record, s TableSchema) (Record, error) {
 				out[fieldName], err = ToGeoJSONPoint(sourceValue)
 			case "location":
 				out[fieldName], err = ToGeoJSONLocation(sourceValue)
+			case "multipoint", "line", "multiline", "polygon", "multipolygon":
+				out[fieldName], err = ToGeoJSON(sourceValue, socrataGeometryTypes[schema.SourceFieldType])
 			default:
-				return nil, fmt.Errorf("unhandled conversion from %q to %q for field %s", schema.SourceFieldType, schema.Type, fieldName)
+				return nil, errs, fmt.Errorf("unhandled conversion from %q to %q for field %s", schema.SourceFieldType, schema.Type, fieldName)
 			}
 		case bigquery.DateFieldType:
 			if sourceValue != nil {
 				var v interface{}
-				v, err = ToDate(schema.TimeFormat, sourceValue.(string))
+				v, err = schema.TimeFormat.Convert(sourceValue.(string), ToDate)
 				out[fieldName] = v
 				if schema.Required && v == nil && err == nil {
 					err = fmt.Errorf("missing required field %q", fieldName)
@@ -139,32 +193,71 @@ func TransformOne(m Record, s TableSchema) (Record, error) {
 			}
 		case bigquery.TimeFieldType:
 			if sourceValue != nil {
-				out[fieldName], err = ToTime(schema.TimeFormat, sourceValue.(string))
+				out[fieldName], err = schema.TimeFormat.Convert(sourceValue.(string), ToTime)
 			} else if schema.Required {
 				err = fmt.Errorf("missing required field %q", fieldName)
 			}
 		case bigquery.TimestampFieldType, bigquery.DateTimeFieldType:
-			out[fieldName] = sourceValue
-			// TODO: improve conversion
+			var loc *time.Location
+			if loc, err = loadLocation(schema.Timezone); err != nil {
+				return nil, errs, fmt.Errorf("field %q %w", fieldName, err)
+			}
+			switch v := sourceValue.(type) {
+			case nil:
+			case string:
+				convert := func(format, s string) (interface{}, error) {
+					if schema.Type == bigquery.TimestampFieldType {
+						return ToTimestamp(format, loc, s)
+					}
+					return ToDateTime(format, loc, s)
+				}
+				out[fieldName], err = schema.TimeFormat.Convert(v, convert)
+			default:
+				err = fmt.Errorf("expected a string got %T", sourceValue)
+			}
+			if schema.Required && out[fieldName] == nil && err == nil {
+				err = fmt.Errorf("missing required field %q", fieldName)
+			}
 		case bigquery.BooleanFieldType:
-			out[fieldName] = sourceValue.(bool)
+			switch v := sourceValue.(type) {
+			case bool, nil:
+				out[fieldName] = v
+			case string:
+				out[fieldName], err = ToBoolean(v)
+			default:
+				err = fmt.Errorf("expected a boolean")
+			}
 		default:
-			return nil, fmt.Errorf("unhandled BigQuery type %q for field %q value %T %#v", schema.Type, fieldName, sourceValue, sourceValue)
+			return nil, errs, fmt.Errorf("unhandled BigQuery type %q for field %q value %T %#v", schema.Type, fieldName, sourceValue, sourceValue)
+		}
+		if normalizeErr != nil {
+			out[fieldName], err = nil, normalizeErr
+		}
+		if err != nil {
+			fe := FieldError{Field: fieldName, SourceField: schema.SourceField, Value: m[schema.SourceField], OnError: schema.OnError, Err: err}
+			if fail(fe) {
+				return nil, errs, err
+			}
+		}
+	}
+	for _, fieldName := range s.computedFields() {
+		schema := s[fieldName]
+		v, err := schema.Computed.Evaluate(out)
+		out[fieldName] = v
+		if err == nil && v == nil && schema.Required {
+			err = fmt.Errorf("missing required field %q", fieldName)
 		}
 		if err != nil {
-			switch schema.OnError {
-			case SkipValue:
-				log.Printf("skipping invalid value %q in field %q %s", sourceValue, schema.SourceField, err)
-				out[fieldName] = nil
-			case SkipRow, "":
-				log.Printf("skipping row. invalid value %q in field %q %s", sourceValue, schema.SourceField, err)
-				return nil, nil
-			case RaiseError:
-				return nil, err
+			fe := FieldError{Field: fieldName, SourceField: "computed." + fieldName, OnError: schema.OnError, Err: err}
+			if fail(fe) {
+				return nil, errs, err
 			}
 		}
 	}
-	return out, nil
+	if skipRow {
+		return nil, errs, nil
+	}
+	return out, errs, nil
 }
 
 func MustGeoJSON(v interface{}, err error) interface{} {
@@ -200,6 +293,41 @@ func ToGeoJSONPoint(v interface{}) (interface{}, error) {
 	b, err := json.Marshal(v)
 	return string(b), err
 }
+
+// socrataGeometryTypes maps Socrata geometry column types to GeoJSON geometry types
+var socrataGeometryTypes = map[string]string{
+	"point":        "Point",
+	"multipoint":   "MultiPoint",
+	"line":         "LineString",
+	"multiline":    "MultiLineString",
+	"polygon":      "Polygon",
+	"multipolygon": "MultiPolygon",
+}
+
+// ToGeoJSON converts a GeoJSON geometry of the expected type (i.e. "MultiPolygon") to
+// a string. Well Known Text values (i.e. "MULTIPOLYGON (((...)))") are returned as is.
+func ToGeoJSON(v interface{}, geometry string) (interface{}, error) {
+	switch m := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if m == "" {
+			return nil, nil
+		}
+		if !strings.HasPrefix(strings.ToUpper(m), strings.ToUpper(geometry)) {
+			return nil, fmt.Errorf("ToGeoJSON: expected %s got %q", geometry, m)
+		}
+		return m, nil
+	case map[string]interface{}:
+		if t, _ := m["type"].(string); t != geometry {
+			return nil, fmt.Errorf("ToGeoJSON: expected %s got %q", geometry, m["type"])
+		}
+		b, err := json.Marshal(m)
+		return string(b), err
+	}
+	return nil, fmt.Errorf("ToGeoJSON: unhandled type %T %#v", v, v)
+}
+
 func ToGeoJSONLocation(v interface{}) (interface{}, error) {
 	if v == nil {
 		return nil, nil
@@ -241,6 +369,57 @@ func ToGeoJSONLocation(v interface{}) (interface{}, error) {
 	return string(b), err
 }
 
+// numberString returns a Socrata number, money, percent or double value as a string;
+// "" for null values
+func numberString(v interface{}) (string, error) {
+	switch n := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return strings.TrimSpace(n), nil
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case json.Number:
+		return n.String(), nil
+	}
+	return "", fmt.Errorf("expected a number got %T", v)
+}
+
+// booleanValues are the text values (lowercase) converted to a BOOLEAN
+var booleanValues = map[string]bool{
+	"y": true, "yes": true, "t": true, "true": true, "1": true,
+	"n": false, "no": false, "f": false, "false": false, "0": false,
+}
+
+// ToBoolean converts Y/N, Yes/No, T/F, True/False or 1/0 (in any case) to a BOOLEAN
+func ToBoolean(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	b, ok := booleanValues[strings.ToLower(s)]
+	if !ok {
+		return nil, fmt.Errorf("invalid BOOLEAN %q", s)
+	}
+	return b, nil
+}
+
+// objectString returns the key of a Socrata url or phone object, or the object as
+// JSON when key is "". Plain strings are returned as is.
+func objectString(v interface{}, key string) (interface{}, error) {
+	switch m := v.(type) {
+	case nil, string:
+		return m, nil
+	case map[string]interface{}:
+		if key != "" {
+			return m[key], nil
+		}
+		b, err := json.Marshal(m)
+		return string(b), err
+	}
+	return nil, fmt.Errorf("unexpected %T", v)
+}
+
 func ToDate(format, s string) (interface{}, error) {
 	if s == "" {
 		return nil, nil
@@ -252,6 +431,104 @@ func ToDate(format, s string) (interface{}, error) {
 	return t.Format("2006-01-02"), nil
 }
 
+// socrataTimeLayouts are tried in order when a TIMESTAMP or DATETIME field has no time_format
+var socrataTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00", // fixed_timestamp and system fields
+	"2006-01-02T15:04:05.999999999",       // floating_timestamp and calendar_date
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+var locations sync.Map
+
+// loadLocation returns the named IANA time zone, or UTC when name is "".
+// Zones are cached because they are loaded for every value.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// floating is a sentinel location for parsed values without a UTC offset; no real offset is 1s
+var floating = time.FixedZone("floating", 1)
+
+// parseTime parses s with format, or the default Socrata layouts when format is "".
+// Values without a UTC offset are in loc (see wallClock).
+func parseTime(format string, loc *time.Location, s string) (time.Time, error) {
+	layouts := socrataTimeLayouts
+	if format != "" {
+		layouts = []string{format}
+	}
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, s, floating); err != nil {
+			continue
+		}
+		if t.Location() == floating {
+			return wallClock(t, loc), nil
+		}
+		return t, nil
+	}
+	return time.Time{}, err
+}
+
+// wallClock returns the time with the same wall clock as t in loc. A time skipped
+// when DST starts is moved forward by the length of the gap, and a time repeated
+// when DST ends is the first occurrence.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	_, before := wall.Add(-12 * time.Hour).In(loc).Zone()
+	_, after := wall.Add(12 * time.Hour).In(loc).Zone()
+	var first time.Time
+	for _, offset := range []int{before, after} {
+		c := wall.Add(-time.Duration(offset) * time.Second)
+		local := c.In(loc)
+		if time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC).Equal(wall) && (first.IsZero() || c.Before(first)) {
+			first = c
+		}
+	}
+	if first.IsZero() {
+		// skipped by a DST change; the offset before the change moves it forward
+		first = wall.Add(-time.Duration(before) * time.Second)
+	}
+	return first.In(loc)
+}
+
+// ToTimestamp converts a time to a BigQuery TIMESTAMP (RFC3339 in UTC)
+func ToTimestamp(format string, loc *time.Location, s string) (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := parseTime(format, loc, s)
+	if err != nil {
+		return nil, err
+	}
+	return t.UTC().Format(time.RFC3339Nano), nil
+}
+
+// ToDateTime converts a time to a BigQuery DATETIME, the wall clock time in loc
+func ToDateTime(format string, loc *time.Location, s string) (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := parseTime(format, loc, s)
+	if err != nil {
+		return nil, err
+	}
+	return t.In(loc).Format("2006-01-02T15:04:05.999999"), nil
+}
+
 func ToTime(format, s string) (interface{}, error) {
 	if s == "" {
 		return nil, nil
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
 )
 
 // func TestTransformOne(t *testing.T) {
@@ -152,3 +157,199 @@ func TestToDate(t *testing.T) {
 		})
 	}
 }
+
+func TestSocrataTypes(t *testing.T) {
+	type testCase struct {
+		socrataType string
+		have        string // Socrata JSON value
+		bqType      bigquery.FieldType
+		expect      interface{}
+		parquet     interface{} // the value read back from a staged Parquet file
+	}
+	tests := []testCase{
+		{"money", `"12.50"`, bigquery.NumericFieldType, "12.50", "12500000000"},
+		{"percent", `"7.5"`, bigquery.NumericFieldType, "7.5", "7500000000"},
+		{"double", `"0.25"`, bigquery.FloatFieldType, 0.25, 0.25},
+		{"double", `0.5`, bigquery.FloatFieldType, 0.5, 0.5},
+		{"phone", `{"phone_number":"555-1234","phone_type":"Cell"}`, bigquery.StringFieldType, "555-1234", "555-1234"},
+		{"email", `"a@example.com"`, bigquery.StringFieldType, "a@example.com", "a@example.com"},
+		{"photo", `"f4b2-photo"`, bigquery.StringFieldType, "f4b2-photo", "f4b2-photo"},
+		{"document", `{"file_id":"f1","filename":"a.pdf"}`, bigquery.StringFieldType, `{"file_id":"f1","filename":"a.pdf"}`, `{"file_id":"f1","filename":"a.pdf"}`},
+		{"html", `"<b>x</b>"`, bigquery.StringFieldType, "<b>x</b>", "<b>x</b>"},
+		{"blob", `"c2a1-blob"`, bigquery.StringFieldType, "c2a1-blob", "c2a1-blob"},
+		{"url", `{"url":"https://example.com","description":"x"}`, bigquery.StringFieldType, "https://example.com", "https://example.com"},
+		{"floating_timestamp", `"2024-01-02T03:04:05.000"`, bigquery.DateTimeFieldType, "2024-01-02T03:04:05", int64(1704164645000000)},
+		{"fixed_timestamp", `"2024-01-02T03:04:05.123Z"`, bigquery.TimestampFieldType, "2024-01-02T03:04:05.123Z", int64(1704164645123000)},
+		{"multipoint", `{"type":"MultiPoint","coordinates":[[0,0],[1,1]]}`, bigquery.GeographyFieldType, `{"coordinates":[[0,0],[1,1]],"type":"MultiPoint"}`, "MULTIPOINT(0 0, 1 1)"},
+		{"line", `{"type":"LineString","coordinates":[[0,0],[1,1]]}`, bigquery.GeographyFieldType, `{"coordinates":[[0,0],[1,1]],"type":"LineString"}`, "LINESTRING(0 0, 1 1)"},
+		{"multiline", `{"type":"MultiLineString","coordinates":[[[0,0],[1,1]]]}`, bigquery.GeographyFieldType, `{"coordinates":[[[0,0],[1,1]]],"type":"MultiLineString"}`, "MULTILINESTRING((0 0, 1 1))"},
+		{"polygon", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`, bigquery.GeographyFieldType, `{"coordinates":[[[0,0],[1,0],[1,1],[0,0]]],"type":"Polygon"}`, "POLYGON((0 0, 1 0, 1 1, 0 0))"},
+		{"multipolygon", `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]]]}`, bigquery.GeographyFieldType, `{"coordinates":[[[[0,0],[1,0],[1,1],[0,0]]]],"type":"MultiPolygon"}`, "MULTIPOLYGON(((0 0, 1 0, 1 1, 0 0)))"},
+		{"multipolygon", `"MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)))"`, bigquery.GeographyFieldType, "MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)))", "MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)))"},
+		{"money", `null`, bigquery.NumericFieldType, nil, nil},
+		{"phone", `null`, bigquery.StringFieldType, nil, nil},
+		{"polygon", `null`, bigquery.GeographyFieldType, nil, nil},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, tc.socrataType), func(t *testing.T) {
+			f, err := NewSchemaField(SocrataColumn{FieldName: "value", DataTypeName: tc.socrataType}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Type != tc.bqType {
+				t.Fatalf("got bigquery_type %s expected %s", f.Type, tc.bqType)
+			}
+			if errs := validateSchemaField(f); len(errs) > 0 {
+				t.Fatalf("invalid schema field %v", errs)
+			}
+			s := TableSchema{"value": f}
+			var have interface{}
+			if err := json.Unmarshal([]byte(tc.have), &have); err != nil {
+				t.Fatal(err)
+			}
+			got, errs, err := TransformRecord(Record{"value": have}, s)
+			if err != nil || len(errs) > 0 {
+				t.Fatalf("unexpected error %v %v", err, errs)
+			}
+			if !reflect.DeepEqual(got["value"], tc.expect) {
+				t.Fatalf("got %#v expected %#v", got["value"], tc.expect)
+			}
+
+			var b bytes.Buffer
+			w, err := newParquetWriter(&b, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Write(got); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			_, columns := readParquet(t, b.Bytes())
+			if !reflect.DeepEqual(columns["value"], []interface{}{tc.parquet}) {
+				t.Errorf("got Parquet %#v expected %#v", columns["value"], tc.parquet)
+			}
+		})
+	}
+}
+
+func TestToGeoJSON(t *testing.T) {
+	if _, err := ToGeoJSON(map[string]interface{}{"type": "Point", "coordinates": []interface{}{0.0, 0.0}}, "Polygon"); err == nil {
+		t.Errorf("expected error for a Point in a polygon column")
+	}
+	if _, err := ToGeoJSON("POINT (0 0)", "MultiPoint"); err == nil {
+		t.Errorf("expected error for a POINT in a multipoint column")
+	}
+}
+
+func TestGuessBQTypeUnknown(t *testing.T) {
+	md := SocrataMetadata{Columns: []SocrataColumn{
+		{FieldName: "name", DataTypeName: "text"},
+		{FieldName: "shape", DataTypeName: "hologram"},
+	}}
+	s := NewSchema(md, nil)
+	if _, ok := s["shape"]; ok {
+		t.Errorf("expected unsupported column to be skipped")
+	}
+	if _, ok := s["name"]; !ok {
+		t.Errorf("expected name field")
+	}
+	if _, _, err := GuessBQType("hologram", "shape"); err == nil {
+		t.Errorf("expected an error for an unknown Socrata type")
+	}
+}
+
+func TestToTimestamp(t *testing.T) {
+	ny, err := loadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type testCase struct {
+		have   string
+		format string
+		loc    *time.Location
+		expect string
+	}
+	tests := []testCase{
+		{"2024-01-02T03:04:05.123Z", "", time.UTC, "2024-01-02T03:04:05.123Z"},
+		{"2024-01-02T03:04:05.000", "", time.UTC, "2024-01-02T03:04:05Z"},
+		{"2024-01-02T03:04:05.000", "", ny, "2024-01-02T08:04:05Z"},
+		{"2024-07-02T03:04:05.000", "", ny, "2024-07-02T07:04:05Z"},
+		// values with an offset ignore the timezone
+		{"2024-01-02T03:04:05-07:00", "", ny, "2024-01-02T10:04:05Z"},
+		{"01/02/2024 03:04 PM", "01/02/2006 03:04 PM", ny, "2024-01-02T20:04:00Z"},
+		// the day DST starts 02:00-03:00 doesn't exist and is moved forward an hour
+		{"2024-03-10T01:59:59.000", "", ny, "2024-03-10T06:59:59Z"},
+		{"2024-03-10T02:30:00.000", "", ny, "2024-03-10T07:30:00Z"},
+		{"2024-03-10T03:00:00.000", "", ny, "2024-03-10T07:00:00Z"},
+		// the day DST ends 01:00-02:00 happens twice; the first (EDT) is used
+		{"2024-11-03T00:59:59.000", "", ny, "2024-11-03T04:59:59Z"},
+		{"2024-11-03T01:30:00.000", "", ny, "2024-11-03T05:30:00Z"},
+		{"2024-11-03T02:00:00.000", "", ny, "2024-11-03T07:00:00Z"},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := ToTimestamp(tc.format, tc.loc, tc.have)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Fatalf("got %q not %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestToDateTime(t *testing.T) {
+	ny, err := loadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type testCase struct {
+		have   string
+		loc    *time.Location
+		expect string
+	}
+	tests := []testCase{
+		{"2024-01-02T03:04:05.000", time.UTC, "2024-01-02T03:04:05"},
+		{"2024-01-02T03:04:05.250", ny, "2024-01-02T03:04:05.25"},
+		{"2024-01-02", time.UTC, "2024-01-02T00:00:00"},
+		// values with an offset are converted to the wall clock time in the timezone
+		{"2024-01-02T03:04:05Z", ny, "2024-01-01T22:04:05"},
+		{"2024-11-03T05:30:00Z", ny, "2024-11-03T01:30:00"},
+		{"2024-11-03T06:30:00Z", ny, "2024-11-03T01:30:00"},
+		{"2024-03-10T07:30:00Z", ny, "2024-03-10T03:30:00"},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := ToDateTime("", tc.loc, tc.have)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Fatalf("got %q not %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestTransformTimezone(t *testing.T) {
+	s := TableSchema{
+		"created": {SourceField: "created", SourceFieldType: "floating_timestamp", Type: bigquery.TimestampFieldType, Timezone: "America/Chicago", Required: true},
+		"local":   {SourceField: "created", SourceFieldType: "floating_timestamp", Type: bigquery.DateTimeFieldType},
+	}
+	got, errs, err := TransformRecord(Record{"created": "2024-06-01T12:00:00.000"}, s)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("unexpected error %v %v", err, errs)
+	}
+	expect := Record{"created": "2024-06-01T17:00:00Z", "local": "2024-06-01T12:00:00"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %#v expected %#v", got, expect)
+	}
+
+	got, errs, _ = TransformRecord(Record{"created": "June 1"}, s)
+	if got != nil || len(errs) != 2 {
+		t.Errorf("expected invalid values to skip the row got %#v %v", got, errs)
+	}
+}
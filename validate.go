@@ -0,0 +1,307 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// supportedConversions lists the Socrata source_field_type values TransformOne can convert
+// to each BigQuery type. An empty source_field_type is used for system fields like :id.
+var supportedConversions = map[bigquery.FieldType][]string{
+	bigquery.StringFieldType:     {"", "text", "url", "email", "phone", "photo", "document", "html", "blob"},
+	bigquery.NumericFieldType:    {"", "text", "number", "money", "percent", "double"},
+	bigquery.BigNumericFieldType: {"", "text", "number", "money", "percent", "double"},
+	bigquery.FloatFieldType:      {"", "text", "number", "money", "percent", "double"},
+	bigquery.IntegerFieldType:    {"", "text", "number", "money", "percent", "double"},
+	bigquery.GeographyFieldType:  {"point", "location", "multipoint", "line", "multiline", "polygon", "multipolygon"},
+	bigquery.DateFieldType:       {"", "text", "calendar_date", "floating_timestamp"},
+	bigquery.TimeFieldType:       {"", "text"},
+	bigquery.TimestampFieldType:  {"", "text", "calendar_date", "floating_timestamp", "fixed_timestamp"},
+	bigquery.DateTimeFieldType:   {"", "text", "calendar_date", "floating_timestamp", "fixed_timestamp"},
+	bigquery.BooleanFieldType:    {"", "text", "checkbox"},
+}
+
+// ValidationError is a problem found in a config file
+type ValidationError struct {
+	File    string
+	Field   string // the schema field or setting
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Field, e.Message)
+}
+
+func validateCmd(args []string) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s validate", os.Args[0]), flag.ExitOnError)
+	if err := flagSet.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flagSet.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "missing filename")
+		os.Exit(1)
+	}
+	var failed bool
+	for _, configFile := range flagSet.Args() {
+		cf, err := LoadConfigFile(configFile)
+		if err != nil {
+			fmt.Printf("%s: %s\n", configFile, err)
+			failed = true
+			continue
+		}
+		errs := cf.Validate()
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		if len(errs) > 0 {
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: OK\n", configFile)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Validate statically checks the config and every schema field without making any API calls
+func (cf ConfigFile) Validate() []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{File: cf.filename, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if u, err := url.Parse(cf.Dataset); cf.Dataset == "" || err != nil || u.Host == "" {
+		add("Dataset", "must be the URL of a Socrata dataset (got %q)", cf.Dataset)
+	}
+	switch cf.StagingMethod() {
+	case StagingGCS:
+		if cf.GoogleStorageBucketName == "" {
+			add("Staging", "%s requires GoogleStorageBucketName", StagingGCS)
+		}
+	case StagingLocal:
+	default:
+		add("Staging", "must be one of GCS, LOCAL (got %q)", cf.Staging)
+	}
+	switch cf.Format() {
+	case FormatJSON, FormatParquet:
+	default:
+		add("StagingFormat", "must be one of JSON, PARQUET (got %q)", cf.StagingFormat)
+	}
+	switch cf.Order() {
+	case PageByCreatedAt, PageByID:
+	default:
+		add("PageOrder", "must be one of %s, %s (got %q)", PageByCreatedAt, PageByID, cf.PageOrder)
+	}
+	if cf.Rejects != "" && cf.Rejects != RejectsTable && !strings.HasPrefix(cf.Rejects, "gs://") {
+		if path, err := outputPath(cf.Rejects); err != nil || path == "-" {
+			add("Rejects", "must be a local path, gs://bucket/path or %s (got %q)", RejectsTable, cf.Rejects)
+		}
+	}
+	if err := cf.MaxErrors.Validate(); err != nil {
+		add("MaxErrors", "%s", err)
+	}
+	if cf.PageSize < 0 {
+		add("PageSize", "must not be negative")
+	}
+	if cf.CheckpointRows < 0 {
+		add("CheckpointRows", "must not be negative")
+	}
+
+	bq := cf.BigQuery
+	for _, s := range []struct{ name, value string }{
+		{"BigQuery.ProjectID", bq.ProjectID},
+		{"BigQuery.DatasetName", bq.DatasetName},
+		{"BigQuery.TableName", bq.TableName},
+	} {
+		if s.value == "" {
+			add(s.name, "must be set")
+		}
+	}
+	requireFields := func(setting string, names ...string) {
+		for _, name := range names {
+			if _, ok := cf.Schema[name]; !ok {
+				add(setting, "requires schema field %q", name)
+			}
+		}
+	}
+	if cf.CheckpointRows > 0 {
+		// a resumed sync removes rows loaded twice by _id
+		requireFields("CheckpointRows", "_id")
+	}
+	switch bq.Mode() {
+	case SyncAppend, SyncReplace:
+	case SyncMerge:
+		requireFields("BigQuery.SyncMode", "_id", "_updated_at")
+	default:
+		add("BigQuery.SyncMode", "must be one of APPEND, MERGE, REPLACE (got %q)", bq.SyncMode)
+	}
+	switch bq.DeleteMode {
+	case "":
+	case DeleteHard, DeleteSoft:
+		requireFields("BigQuery.DeleteMode", "_id")
+	default:
+		add("BigQuery.DeleteMode", "must be one of HARD, SOFT (got %q)", bq.DeleteMode)
+	}
+
+	if len(cf.Schema) == 0 {
+		add("schema", "no fields configured")
+	}
+	var names []string
+	for name := range cf.Schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sourceFields := make(map[string]string)
+	for _, name := range names {
+		if c := cf.Schema[name].Computed; c != nil {
+			for _, msg := range validateComputedField(*c, cf.Schema) {
+				add("computed."+name, "%s", msg)
+			}
+			continue
+		}
+		for _, msg := range validateSchemaField(cf.Schema[name]) {
+			add("schema."+name, "%s", msg)
+		}
+		if sf := cf.Schema[name].SourceField; sf != "" {
+			if other, ok := sourceFields[sf]; ok {
+				add("schema."+name, "source_field %q is also mapped by schema.%s", sf, other)
+			} else {
+				sourceFields[sf] = name
+			}
+		}
+	}
+	if _, err := cf.Schema.TimePartitioning(); err != nil {
+		add("schema", "%s", err)
+	}
+	return errs
+}
+
+// validateSchemaField returns the problems with a single schema field
+func validateSchemaField(f SchemaField) []string {
+	var errs []string
+	if f.SourceField == "" {
+		errs = append(errs, "source_field must be set")
+	}
+	sourceTypes, ok := supportedConversions[f.Type]
+	switch {
+	case f.Type == "":
+		errs = append(errs, "bigquery_type must be set")
+	case !ok:
+		errs = append(errs, fmt.Sprintf("unsupported bigquery_type %q", f.Type))
+	case !slices.Contains(sourceTypes, f.SourceFieldType):
+		errs = append(errs, fmt.Sprintf("unsupported conversion from source_field_type %q to bigquery_type %s", f.SourceFieldType, f.Type))
+	}
+	switch f.OnError {
+	case "", SkipValue, SkipRow, RaiseError:
+	default:
+		errs = append(errs, fmt.Sprintf("on_error must be one of SKIP_VALUE, SKIP_ROW, ERROR (got %q)", f.OnError))
+	}
+	if err := f.MaxErrors.Validate(); err != nil {
+		errs = append(errs, "max_errors "+err.Error())
+	}
+	switch f.Case {
+	case "", UpperCase, LowerCase:
+	default:
+		errs = append(errs, fmt.Sprintf("case must be one of UPPER, LOWER (got %q)", f.Case))
+	}
+	for _, pattern := range []string{f.RegexReplace, f.RegexExtract} {
+		if pattern == "" {
+			continue
+		}
+		if _, err := compileRegex(pattern); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if f.Replacement != "" && f.RegexReplace == "" {
+		errs = append(errs, "replacement requires regex_replace")
+	}
+	switch f.Rounding {
+	case "", RoundTruncate, RoundHalfEven:
+	default:
+		errs = append(errs, fmt.Sprintf("rounding must be one of TRUNCATE, HALF_EVEN (got %q)", f.Rounding))
+	}
+	if f.Scale != nil {
+		maxScale := 38
+		if limits, ok := numericLimits[f.Type]; ok {
+			maxScale = limits.scale
+		}
+		switch {
+		case f.Type != bigquery.NumericFieldType && f.Type != bigquery.BigNumericFieldType && f.Type != bigquery.FloatFieldType:
+			errs = append(errs, fmt.Sprintf("scale is only supported for NUMERIC, BIGNUMERIC and FLOAT (got %s)", f.Type))
+		case *f.Scale < 0 || *f.Scale > maxScale:
+			errs = append(errs, fmt.Sprintf("scale must be between 0 and %d for %s (got %d)", maxScale, f.Type, *f.Scale))
+		}
+	}
+	if f.Rounding != "" {
+		switch f.Type {
+		case bigquery.IntegerFieldType, bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		case bigquery.FloatFieldType:
+			if f.Scale == nil {
+				errs = append(errs, "rounding requires scale for FLOAT")
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("rounding is only supported for INTEGER, NUMERIC, BIGNUMERIC and FLOAT (got %s)", f.Type))
+		}
+	}
+	if f.Timezone != "" {
+		if f.Type != bigquery.TimestampFieldType && f.Type != bigquery.DateTimeFieldType {
+			errs = append(errs, fmt.Sprintf("timezone is only supported for TIMESTAMP and DATETIME (got %s)", f.Type))
+		} else if _, err := loadLocation(f.Timezone); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	switch f.Type {
+	case bigquery.DateFieldType, bigquery.TimeFieldType, bigquery.TimestampFieldType, bigquery.DateTimeFieldType:
+		if len(f.TimeFormat) == 0 && (f.Type == bigquery.DateFieldType || f.Type == bigquery.TimeFieldType) {
+			errs = append(errs, fmt.Sprintf("time_format is required for %s", f.Type))
+		}
+		for _, format := range f.TimeFormat {
+			if err := validateTimeFormat(f.Type, format); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	return errs
+}
+
+// validateTimeFormat checks that a time.Parse layout round trips the parts of a time the BigQuery type needs
+func validateTimeFormat(t bigquery.FieldType, format string) error {
+	ref := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	switch t {
+	case bigquery.TimestampFieldType, bigquery.DateTimeFieldType:
+		v, err := ToDateTime(format, time.UTC, ref.Format(format))
+		if err != nil || !strings.HasPrefix(v.(string), "2006-01-02") {
+			return fmt.Errorf("time_format %q does not parse a year, month and day", format)
+		}
+	case bigquery.DateFieldType:
+		v, err := ToDate(format, ref.Format(format))
+		if err != nil || v != ref.Format("2006-01-02") {
+			return fmt.Errorf("time_format %q does not parse a year, month and day", format)
+		}
+	case bigquery.TimeFieldType:
+		// ToTime matches case-insensitively and accepts a trailing "p" for "pm"
+		layout := strings.ToLower(format)
+		s := ref.Format(layout)
+		if strings.HasSuffix(layout, "p") {
+			s = strings.TrimSuffix(ref.Format(layout+"m"), "m")
+		}
+		v, err := ToTime(format, s)
+		if err != nil || v == nil || !strings.HasPrefix(v.(string), "15:04") {
+			return fmt.Errorf("time_format %q does not parse an hour and minute", format)
+		}
+	}
+	return nil
+}
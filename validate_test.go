@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestValidateTimeFormat(t *testing.T) {
+	tests := []struct {
+		t      bigquery.FieldType
+		format string
+		ok     bool
+	}{
+		{bigquery.DateFieldType, "2006/01/02", true},
+		{bigquery.DateFieldType, "01/02/2006", true},
+		{bigquery.DateFieldType, "2006-01-02T15:04:05.000", true},
+		{bigquery.DateFieldType, "YYYY-MM-DD", false},
+		{bigquery.DateFieldType, "01/2006", false},
+		{bigquery.TimeFieldType, "03:04pm", true},
+		{bigquery.TimeFieldType, "0304p", true},
+		{bigquery.TimeFieldType, "15:04", true},
+		{bigquery.TimeFieldType, "HH:MM", false},
+		{bigquery.TimeFieldType, "03:04", false}, // 12 hour clock without am/pm
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			err := validateTimeFormat(tc.t, tc.format)
+			if tc.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tc.ok && err == nil {
+				t.Fatalf("expected error for %q", tc.format)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	scale := 12
+	cf := ConfigFile{
+		Config: Config{
+			Dataset:   "https://data.example.com/d/abcd-1234",
+			PageOrder: "updated",
+			MaxErrors: "1.5",
+			BigQuery: BigQuery{
+				ProjectID:   "p",
+				DatasetName: "d",
+				SyncMode:    SyncMerge,
+				DeleteMode:  "SOMETIMES",
+			},
+		},
+		Schema: TableSchema{
+			"_id":      {SourceField: ":id", Type: bigquery.StringFieldType, Required: true},
+			"name":     {SourceField: "name", SourceFieldType: "text", Type: bigquery.StringFieldType},
+			"name_2":   {SourceField: "name", SourceFieldType: "text", Type: bigquery.StringFieldType, OnError: "IGNORE", MaxErrors: "x%"},
+			"location": {SourceField: "location", SourceFieldType: "text", Type: bigquery.GeographyFieldType},
+			"day":      {SourceField: "day", SourceFieldType: "text", Type: bigquery.DateFieldType, TimeFormat: TimeFormats{"YYYY-MM-DD"}},
+			"month":    {SourceField: "month", Type: "MONTH"},
+			"local":    {SourceField: "local", SourceFieldType: "floating_timestamp", Type: bigquery.DateTimeFieldType, Timezone: "Mars/Olympus_Mons"},
+			"created":  {SourceField: ":created_at", Type: bigquery.TimestampFieldType, TimePartition: TimePartitionDay},
+			"amount":   {SourceField: "amount", SourceFieldType: "number", Type: bigquery.NumericFieldType, Scale: &scale, Rounding: "UP"},
+			"ratio":    {SourceField: "ratio", SourceFieldType: "double", Type: bigquery.FloatFieldType, Rounding: RoundHalfEven},
+			"label":    {SourceField: "label", SourceFieldType: "text", Type: bigquery.StringFieldType, Scale: &scale},
+			"code":     {SourceField: "code", SourceFieldType: "text", Type: bigquery.StringFieldType, Case: "TITLE", RegexExtract: "(", Replacement: "x"},
+		},
+		filename: "config.toml",
+	}
+	var got []string
+	for _, e := range cf.Validate() {
+		got = append(got, e.Error())
+	}
+	expect := []string{
+		`config.toml: PageOrder: must be one of :created_at, :id (got "updated")`,
+		`config.toml: MaxErrors: invalid error budget "1.5" (expected a count like "100" or a percentage like "0.5%")`,
+		`config.toml: BigQuery.TableName: must be set`,
+		`config.toml: BigQuery.SyncMode: requires schema field "_updated_at"`,
+		`config.toml: BigQuery.DeleteMode: must be one of HARD, SOFT (got "SOMETIMES")`,
+		`config.toml: schema.amount: rounding must be one of TRUNCATE, HALF_EVEN (got "UP")`,
+		`config.toml: schema.amount: scale must be between 0 and 9 for NUMERIC (got 12)`,
+		`config.toml: schema.code: case must be one of UPPER, LOWER (got "TITLE")`,
+		"config.toml: schema.code: invalid regular expression \"(\" error parsing regexp: missing closing ): `(`",
+		`config.toml: schema.code: replacement requires regex_replace`,
+		`config.toml: schema.day: time_format "YYYY-MM-DD" does not parse a year, month and day`,
+		`config.toml: schema.label: scale is only supported for NUMERIC, BIGNUMERIC and FLOAT (got STRING)`,
+		`config.toml: schema.local: invalid timezone "Mars/Olympus_Mons"`,
+		`config.toml: schema.location: unsupported conversion from source_field_type "text" to bigquery_type GEOGRAPHY`,
+		`config.toml: schema.month: unsupported bigquery_type "MONTH"`,
+		`config.toml: schema.name_2: on_error must be one of SKIP_VALUE, SKIP_ROW, ERROR (got "IGNORE")`,
+		`config.toml: schema.name_2: max_errors invalid error budget "x%" (expected a count like "100" or a percentage like "0.5%")`,
+		`config.toml: schema.name_2: source_field "name" is also mapped by schema.name`,
+		`config.toml: schema.ratio: rounding requires scale for FLOAT`,
+		`config.toml: schema: time_partition field "created" must be required`,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got\n%q\nexpected\n%q", got, expect)
+	}
+}
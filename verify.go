@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// DateRange is a run of consecutive days where the Socrata and BigQuery counts differ
+type DateRange struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Socrata  int64  `json:"socrata"`
+	BigQuery int64  `json:"bigquery"`
+}
+
+func (r DateRange) String() string {
+	days := r.From
+	if days == "" {
+		days = "no date"
+	} else if r.To != r.From {
+		days += " to " + r.To
+	}
+	diff := r.Socrata - r.BigQuery
+	if diff > 0 {
+		return fmt.Sprintf("%s: Socrata %d, BigQuery %d (%d missing)", days, r.Socrata, r.BigQuery, diff)
+	}
+	return fmt.Sprintf("%s: Socrata %d, BigQuery %d (%d extra)", days, r.Socrata, r.BigQuery, -diff)
+}
+
+// Verification compares the records in Socrata with the rows in BigQuery
+type Verification struct {
+	Config    string      `json:"config"`
+	DatasetID string      `json:"dataset_id"`
+	Table     string      `json:"table"`
+	Socrata   int64       `json:"socrata"`
+	BigQuery  int64       `json:"bigquery"`
+	Daily     bool        `json:"daily"` // per-day counts were compared
+	Ranges    []DateRange `json:"ranges"`
+	SkipRow   []string    `json:"skip_row_fields,omitempty"` // fields whose invalid values skip the row
+}
+
+func (v Verification) OK() bool {
+	return v.Socrata == v.BigQuery && len(v.Ranges) == 0
+}
+
+func verifyCmd(args []string) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s verify", os.Args[0]), flag.ExitOnError)
+	token := flagSet.String("socrata-app-token", "", "Socrata App Token (also src SOCRATA_APP_TOKEN env)")
+	retries := flagSet.Int("socrata-retries", DefaultRetryPolicy.MaxRetries, "number of times to retry failed Socrata API requests")
+	jsonOutput := flagSet.Bool("json", false, "output JSON")
+	if err := flagSet.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	socrataRetry.MaxRetries = *retries
+	if *token == "" {
+		*token = os.Getenv("SOCRATA_APP_TOKEN")
+	}
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "missing --socrata-app-token or environment variable SOCRATA_APP_TOKEN")
+		os.Exit(1)
+	}
+
+	if flagSet.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "missing filename")
+		os.Exit(1)
+	}
+	ctx := context.Background()
+	var results []Verification
+	var failed bool
+	for _, configFile := range flagSet.Args() {
+		cf, err := LoadConfigFile(configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bqclient, err := bigquery.NewClient(ctx, cf.BigQuery.ProjectID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		v, err := verifyTable(ctx, bqclient, cf, *token)
+		_ = bqclient.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !*jsonOutput {
+			printVerification(v)
+		}
+		results = append(results, v)
+		failed = failed || !v.OK()
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// verifyTable compares the Socrata record count with the BigQuery row count and, when
+// the schema maps :created_at, the counts for each day records were created. Rows
+// skipped by on_error = SKIP_ROW are never loaded so they are counted as missing.
+func verifyTable(ctx context.Context, bqclient *bigquery.Client, cf ConfigFile, token string) (Verification, error) {
+	v := Verification{Config: cf.filename, DatasetID: cf.DatasetID(), Table: cf.BigQuery.SQLTableName(), SkipRow: skipRowFields(cf.Schema)}
+	var err error
+	v.Socrata, err = CountV3(ctx, cf.APIBase(), v.DatasetID, cf.BigQuery.WhereFilter, token)
+	if err != nil {
+		return v, err
+	}
+	v.BigQuery, err = countRows(ctx, bqclient, cf)
+	if err != nil {
+		return v, err
+	}
+
+	column := createdAtColumn(cf.Schema)
+	if column == "" {
+		return v, nil
+	}
+	socrata, err := socrataDailyCounts(ctx, cf.APIBase(), v.DatasetID, cf.BigQuery.WhereFilter, token)
+	if err != nil {
+		return v, err
+	}
+	bq, err := bigqueryDailyCounts(ctx, bqclient, cf, column)
+	if err != nil {
+		return v, err
+	}
+	v.Daily = true
+	v.Ranges = divergentRanges(socrata, bq)
+	return v, nil
+}
+
+// verifySync runs verifyTable after a sync and reports whether BigQuery matches Socrata
+func verifySync(ctx context.Context, bqclient *bigquery.Client, cf ConfigFile, token string) bool {
+	v, err := verifyTable(ctx, bqclient, cf, token)
+	if err != nil {
+		fmt.Printf("WARNING: verification failed: %s\n", err)
+		return false
+	}
+	printVerification(v)
+	return v.OK()
+}
+
+func printVerification(v Verification) {
+	fmt.Printf("Verifying %s (Socrata %s, BigQuery %s)\n", v.Config, v.DatasetID, v.Table)
+	fmt.Printf("  Socrata Records: %d\n", v.Socrata)
+	fmt.Printf("  BQ Records: %d\n", v.BigQuery)
+	if !v.Daily {
+		fmt.Printf("  per-day counts not compared: no schema field has source_field \":created_at\"\n")
+	}
+	for _, r := range v.Ranges {
+		fmt.Printf("  %s\n", r)
+	}
+	if len(v.SkipRow) > 0 && !v.OK() {
+		fmt.Printf("  rows skipped by on_error = SKIP_ROW (%s) are counted as missing\n", strings.Join(v.SkipRow, ", "))
+	}
+	if v.OK() {
+		fmt.Printf("  OK\n")
+	} else {
+		fmt.Printf("  MISMATCH\n")
+	}
+}
+
+// skipRowFields returns the schema fields with on_error = SKIP_ROW
+func skipRowFields(s TableSchema) []string {
+	var names []string
+	for name, f := range s {
+		if f.OnError == SkipRow {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createdAtColumn returns the BigQuery column populated from :created_at, or "" if there isn't one
+func createdAtColumn(s TableSchema) string {
+	var names []string
+	for name, f := range s {
+		if f.SourceField == ":created_at" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// socrataDailyCounts returns the number of records created on each day (YYYY-MM-DD)
+func socrataDailyCounts(ctx context.Context, apiBase *url.URL, datasetID, where, token string) (map[string]int64, error) {
+	sql := "SELECT date_trunc_ymd(:created_at) AS created_day, COUNT(*) AS count"
+	if where != "" {
+		sql += " WHERE " + where
+	}
+	sql += " GROUP BY created_day"
+	counts := make(map[string]int64)
+	err := StreamV3(ctx, apiBase, datasetID, sql, token, func(row Record) error {
+		day, _ := row["created_day"].(string)
+		count, _ := row["count"].(string)
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid count %q for %s", count, day)
+		}
+		// floating timestamps are returned as 2024-01-02T00:00:00.000
+		day, _, _ = strings.Cut(day, "T")
+		counts[day] += n
+		return nil
+	})
+	return counts, err
+}
+
+// bigqueryDailyCounts returns the number of rows with column on each day (YYYY-MM-DD)
+func bigqueryDailyCounts(ctx context.Context, bqclient *bigquery.Client, cf ConfigFile, column string) (map[string]int64, error) {
+	sql := fmt.Sprintf("SELECT IFNULL(FORMAT_DATE('%%F', DATE(%s)), '') AS day, COUNT(*) AS value FROM %s", bqIdentifier(column), cf.BigQuery.SQLTableName())
+	if where := liveRowsFilter(cf); len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " GROUP BY day"
+	it, err := bqclient.Query(sql).Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	for {
+		var r struct {
+			Day   string
+			Value int64
+		}
+		err := it.Next(&r)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		counts[r.Day] += r.Value
+	}
+	return counts, nil
+}
+
+// divergentRanges compares per-day counts and coalesces consecutive days where they
+// differ into ranges. Days are YYYY-MM-DD; a day without a date ("") is reported on its own.
+func divergentRanges(socrata, bq map[string]int64) []DateRange {
+	days := make(map[string]bool)
+	for d := range socrata {
+		days[d] = true
+	}
+	for d := range bq {
+		days[d] = true
+	}
+	var sorted []string
+	for d := range days {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+
+	var ranges []DateRange
+	var last time.Time
+	for _, d := range sorted {
+		if socrata[d] == bq[d] {
+			last = time.Time{}
+			continue
+		}
+		day, err := time.Parse("2006-01-02", d)
+		if err == nil && !last.IsZero() && day.Equal(last.AddDate(0, 0, 1)) {
+			r := &ranges[len(ranges)-1]
+			r.To = d
+			r.Socrata += socrata[d]
+			r.BigQuery += bq[d]
+		} else {
+			ranges = append(ranges, DateRange{From: d, To: d, Socrata: socrata[d], BigQuery: bq[d]})
+		}
+		last = day
+		if err != nil {
+			last = time.Time{}
+		}
+	}
+	return ranges
+}
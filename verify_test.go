@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDivergentRanges(t *testing.T) {
+	tests := []struct {
+		socrata, bq map[string]int64
+		expect      []DateRange
+	}{
+		{map[string]int64{"2024-01-01": 5}, map[string]int64{"2024-01-01": 5}, nil},
+		{
+			map[string]int64{"2024-01-01": 5, "2024-01-02": 3, "2024-01-03": 4, "2024-01-04": 1},
+			map[string]int64{"2024-01-01": 5, "2024-01-02": 1, "2024-01-04": 1},
+			[]DateRange{{From: "2024-01-02", To: "2024-01-03", Socrata: 7, BigQuery: 1}},
+		},
+		{
+			// a matching day splits ranges
+			map[string]int64{"2024-01-01": 1, "2024-01-02": 2, "2024-01-03": 3},
+			map[string]int64{"2024-01-02": 2, "2024-01-03": 4},
+			[]DateRange{{From: "2024-01-01", To: "2024-01-01", Socrata: 1}, {From: "2024-01-03", To: "2024-01-03", Socrata: 3, BigQuery: 4}},
+		},
+		{
+			// days missing from both sides are not consecutive
+			map[string]int64{"2024-01-01": 1, "2024-01-03": 1},
+			map[string]int64{},
+			[]DateRange{{From: "2024-01-01", To: "2024-01-01", Socrata: 1}, {From: "2024-01-03", To: "2024-01-03", Socrata: 1}},
+		},
+		{
+			// across a month boundary
+			map[string]int64{"2024-02-28": 1, "2024-02-29": 1, "2024-03-01": 1},
+			map[string]int64{},
+			[]DateRange{{From: "2024-02-28", To: "2024-03-01", Socrata: 3}},
+		},
+		{
+			map[string]int64{"2024-01-01": 1},
+			map[string]int64{"": 2, "2024-01-01": 1},
+			[]DateRange{{From: "", To: "", BigQuery: 2}},
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got := divergentRanges(tc.socrata, tc.bq)
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("got %#v expected %#v", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestDateRangeString(t *testing.T) {
+	tests := []struct {
+		r      DateRange
+		expect string
+	}{
+		{DateRange{From: "2024-01-02", To: "2024-01-02", Socrata: 3, BigQuery: 1}, "2024-01-02: Socrata 3, BigQuery 1 (2 missing)"},
+		{DateRange{From: "2024-01-02", To: "2024-01-05", Socrata: 3, BigQuery: 4}, "2024-01-02 to 2024-01-05: Socrata 3, BigQuery 4 (1 extra)"},
+		{DateRange{BigQuery: 2}, "no date: Socrata 0, BigQuery 2 (2 extra)"},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if got := tc.r.String(); got != tc.expect {
+				t.Errorf("got %q expected %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestSkipRowFields(t *testing.T) {
+	s := TableSchema{
+		"b": {OnError: SkipRow},
+		"a": {OnError: SkipRow},
+		"c": {OnError: SkipValue},
+		"d": {},
+	}
+	if got := skipRowFields(s); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("got %q expected [a b]", got)
+	}
+}